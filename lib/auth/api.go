@@ -0,0 +1,165 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"time"
+
+	"github.com/gravitational/teleport/lib/events/boltlog"
+	"github.com/gravitational/teleport/lib/recorder"
+	"github.com/gravitational/teleport/lib/services"
+	"github.com/gravitational/teleport/lib/session"
+)
+
+// APIWithRoles sits in front of an AuthServer and enforces, for every
+// call, that the caller's Role is permitted to invoke that method. It is
+// the only thing TunServer ever talks to; nothing reaches the AuthServer
+// without going through a permission check first.
+type APIWithRoles struct {
+	authServer  *AuthServer
+	eventLog    *boltlog.BoltLog
+	sessions    session.Service
+	rec         recorder.Recorder
+	permissions Permissions
+	roles       []Role
+}
+
+// NewAPIWithRoles wires an AuthServer together with the audit log,
+// session store and recorder, and the permission matrix that governs
+// which of roles may call which RPC.
+func NewAPIWithRoles(a *AuthServer, eventLog *boltlog.BoltLog, sessions session.Service, rec recorder.Recorder, permissions Permissions, roles []Role) *APIWithRoles {
+	return &APIWithRoles{
+		authServer:  a,
+		eventLog:    eventLog,
+		sessions:    sessions,
+		rec:         rec,
+		permissions: permissions,
+		roles:       roles,
+	}
+}
+
+// Serve starts any background processing APIWithRoles needs; currently a
+// no-op hook reserved for future housekeeping (e.g. expiring sessions).
+func (api *APIWithRoles) Serve() {
+}
+
+// Close does nothing and returns nil: the backend, audit log and recorder
+// APIWithRoles was given are closed by whoever opened them.
+func (api *APIWithRoles) Close() error {
+	return nil
+}
+
+func (api *APIWithRoles) checkPermission(role Role, method string) error {
+	return api.permissions.CheckPermission(role, method)
+}
+
+// UpsertServer registers a node in the cluster inventory on behalf of role.
+func (api *APIWithRoles) UpsertServer(role Role, s services.Server, ttl time.Duration) error {
+	if err := api.checkPermission(role, "UpsertServer"); err != nil {
+		return err
+	}
+	return api.authServer.UpsertServer(s, ttl)
+}
+
+// GetServers returns the cluster's node inventory on behalf of role.
+func (api *APIWithRoles) GetServers(role Role) ([]services.Server, error) {
+	if err := api.checkPermission(role, "GetServers"); err != nil {
+		return nil, err
+	}
+	return api.authServer.GetServers()
+}
+
+// GetUsers returns the list of enrolled users on behalf of role.
+func (api *APIWithRoles) GetUsers(role Role) ([]string, error) {
+	if err := api.checkPermission(role, "GetUsers"); err != nil {
+		return nil, err
+	}
+	return api.authServer.GetUsers()
+}
+
+// SignIn authenticates user with password and returns a new web session
+// id, on behalf of role.
+func (api *APIWithRoles) SignIn(role Role, user string, password []byte) (string, error) {
+	if err := api.checkPermission(role, "SignIn"); err != nil {
+		return "", err
+	}
+	return api.authServer.SignIn(user, password)
+}
+
+// GetWebSession returns a previously issued session id on behalf of role.
+func (api *APIWithRoles) GetWebSession(role Role, user, sid string) (string, error) {
+	if err := api.checkPermission(role, "GetWebSession"); err != nil {
+		return "", err
+	}
+	return api.authServer.GetWebSession(user, sid)
+}
+
+// DeleteWebSession revokes a session id on behalf of role.
+func (api *APIWithRoles) DeleteWebSession(role Role, user, sid string) error {
+	if err := api.checkPermission(role, "DeleteWebSession"); err != nil {
+		return err
+	}
+	return api.authServer.DeleteWebSession(user, sid)
+}
+
+// GetSignupTokenData returns signup enrollment data on behalf of role.
+func (api *APIWithRoles) GetSignupTokenData(role Role, token string) (string, []byte, []string, error) {
+	if err := api.checkPermission(role, "GetSignupTokenData"); err != nil {
+		return "", nil, nil, err
+	}
+	return api.authServer.GetSignupTokenData(token)
+}
+
+// CreateUserWithToken completes a signup on behalf of role, returning the
+// backup codes minted for the new user.
+func (api *APIWithRoles) CreateUserWithToken(role Role, token, password, hotpToken string) ([]string, error) {
+	if err := api.checkPermission(role, "CreateUserWithToken"); err != nil {
+		return nil, err
+	}
+	return api.authServer.CreateUserWithToken(token, password, hotpToken)
+}
+
+// GetKRL returns the current certificate revocation list on behalf of
+// role. Nodes and proxies poll this to keep their local revocation check
+// current.
+func (api *APIWithRoles) GetKRL(role Role) ([]byte, error) {
+	if err := api.checkPermission(role, "GetKRL"); err != nil {
+		return nil, err
+	}
+	return api.authServer.GetKRL()
+}
+
+// UnlockUser lifts an account lockout early, on behalf of role. Only
+// RoleAdmin is permitted to call it.
+func (api *APIWithRoles) UnlockUser(role Role, user string) error {
+	if err := api.checkPermission(role, "UnlockUser"); err != nil {
+		return err
+	}
+	return api.authServer.UnlockUser(user)
+}
+
+// GetSSOSession returns the web session and user cert minted for an
+// already-validated SSO callback. sid and cert are the ones established
+// during the tunnel handshake for this connection, never values supplied
+// by the caller, so a RoleSSOCallback connection can only ever fetch its
+// own session and cert.
+func (api *APIWithRoles) GetSSOSession(role Role, sid string, cert []byte) (string, []byte, error) {
+	if err := api.checkPermission(role, "GetSSOSession"); err != nil {
+		return "", nil, err
+	}
+	return sid, cert, nil
+}
@@ -0,0 +1,519 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth implements the teleport auth server: it holds the host and
+// user certificate authorities, the user/password/OTP store, signup
+// tokens and web sessions, and is reachable locally or over the tunnel
+// via APIWithRoles.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gravitational/teleport/lib/backend"
+	"github.com/gravitational/teleport/lib/events/boltlog"
+	"github.com/gravitational/teleport/lib/services"
+
+	"github.com/mailgun/lemma/secret"
+	"golang.org/x/crypto/ssh"
+)
+
+// SignupTokenUserActionsTTL is how long a freshly created signup token
+// remains valid for the *user* to act on (scan the QR code, pick a
+// password). CreateSignupToken grants a somewhat longer TTL so the token
+// survives being displayed by an admin before the user gets to it.
+const SignupTokenUserActionsTTL = time.Hour
+
+// TokenTTLAfterUse is how long a signup token remains valid, for replay
+// protection, after it has already been consumed once. It is a var (not
+// a const) so tests can shrink it.
+var TokenTTLAfterUse = time.Minute
+
+const signupTokenTTL = SignupTokenUserActionsTTL + time.Hour
+
+// CertAuthority mints SSH host and user certificates. AuthServer uses it
+// to sign certs against whichever CA private key is currently active;
+// testauthority.Keygen is the test-only implementation, a hardware- or
+// software-backed signer is used in production.
+type CertAuthority interface {
+	// GenerateKeyPair returns a new SSH key pair.
+	GenerateKeyPair(passphrase string) (priv []byte, pub []byte, err error)
+	// GenerateHostCert signs a host certificate over pub with caPriv,
+	// stamping it with serial so it can later be individually revoked.
+	GenerateHostCert(caPriv []byte, pub []byte, hostname, authDomain string, serial uint64, ttl int64) ([]byte, error)
+	// GenerateUserCert signs a user certificate over pub with caPriv,
+	// stamping it with serial so it can later be individually revoked.
+	GenerateUserCert(caPriv []byte, pub []byte, username string, serial uint64, ttl int64) ([]byte, error)
+}
+
+// AuthServer is the certificate authority and identity store for a
+// teleport cluster. It is normally not reached directly by clients;
+// APIWithRoles and TunServer enforce permissions in front of it.
+type AuthServer struct {
+	bk         backend.Backend
+	authority  CertAuthority
+	scrt       secret.SecretService
+	DomainName string
+
+	// WebService stores signup tokens and web sessions.
+	WebService services.WebService
+
+	// passwords verifies and (for writable backends) sets user
+	// passwords. It defaults to a backend-native store; WithPasswordBackend
+	// swaps in an htpasswd file, PAM, or any other PasswordBackend.
+	passwords PasswordBackend
+
+	// connectors holds the registered SSO identity providers.
+	connectors *connectorRegistry
+
+	// auditLog records lockout events, if configured with WithAuditLog.
+	auditLog *boltlog.BoltLog
+
+	// serialMu serializes nextSerial's read-modify-write of a CA's serial
+	// high-water mark, since GenerateUserCert/GenerateHostCert can be
+	// called concurrently from the tunnel and the backend itself has no
+	// CAS primitive to rely on instead.
+	serialMu sync.Mutex
+}
+
+// AuthServerOption configures optional AuthServer behavior at
+// construction time; see WithPasswordBackend.
+type AuthServerOption func(*AuthServer)
+
+// WithPasswordBackend overrides the default bolt-backed password store,
+// letting teleport check credentials against an existing htpasswd file
+// or PAM instead of importing every account into its own backend.
+func WithPasswordBackend(pb PasswordBackend) AuthServerOption {
+	return func(a *AuthServer) {
+		a.passwords = pb
+	}
+}
+
+// WithAuditLog lets AuthServer itself emit audit events, currently only
+// for account lockouts, into the same log APIWithRoles is wired up with.
+func WithAuditLog(auditLog *boltlog.BoltLog) AuthServerOption {
+	return func(a *AuthServer) {
+		a.auditLog = auditLog
+	}
+}
+
+// NewAuthServer creates an auth server persisting to bk, signing
+// certificates with authority, and sealing secrets with scrt. domainName
+// identifies this cluster in issued certificates. By default passwords
+// are checked against bk itself; pass WithPasswordBackend to use a
+// different PasswordBackend.
+func NewAuthServer(bk backend.Backend, authority CertAuthority, scrt secret.SecretService, domainName string, opts ...AuthServerOption) *AuthServer {
+	a := &AuthServer{
+		bk:         bk,
+		authority:  authority,
+		scrt:       scrt,
+		DomainName: domainName,
+		WebService: newWebService(bk),
+		passwords:  newBoltPasswordBackend(bk),
+		connectors: newConnectorRegistry(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+var hostCAPath = []string{"ca", "host"}
+var userCAPath = []string{"ca", "user"}
+
+const caPrivKey = "priv"
+
+// ResetHostCertificateAuthority generates a brand new host CA key pair,
+// discarding the previous one. passphrase protects the new private key
+// at rest if the backend supports it.
+func (a *AuthServer) ResetHostCertificateAuthority(passphrase string) error {
+	priv, _, err := a.authority.GenerateKeyPair(passphrase)
+	if err != nil {
+		return err
+	}
+	return a.bk.UpsertVal(hostCAPath, caPrivKey, priv, 0)
+}
+
+// EnsureHostCertificateAuthority generates a host CA key pair the first
+// time it is called for a given backend, and does nothing on subsequent
+// calls; unlike ResetHostCertificateAuthority it is safe to call on
+// every startup of an already-initialized cluster, since resetting an
+// in-use CA would invalidate every certificate it already signed.
+func (a *AuthServer) EnsureHostCertificateAuthority(passphrase string) error {
+	if _, err := a.bk.GetVal(hostCAPath, caPrivKey); err == nil {
+		return nil
+	}
+	return a.ResetHostCertificateAuthority(passphrase)
+}
+
+// ResetUserCertificateAuthority generates a brand new user CA key pair,
+// discarding the previous one.
+func (a *AuthServer) ResetUserCertificateAuthority(passphrase string) error {
+	priv, _, err := a.authority.GenerateKeyPair(passphrase)
+	if err != nil {
+		return err
+	}
+	return a.bk.UpsertVal(userCAPath, caPrivKey, priv, 0)
+}
+
+// userCAPublicKey returns the user certificate authority's current public
+// key, for verifying that a presented user certificate was actually
+// signed by this cluster rather than some other key.
+func (a *AuthServer) userCAPublicKey() (ssh.PublicKey, error) {
+	return a.caPublicKey(userCAPath)
+}
+
+func (a *AuthServer) caPublicKey(path []string) (ssh.PublicKey, error) {
+	priv, err := a.bk.GetVal(path, caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
+
+// GenerateKeyPair returns a new SSH key pair, unrelated to any CA.
+func (a *AuthServer) GenerateKeyPair(passphrase string) ([]byte, []byte, error) {
+	return a.authority.GenerateKeyPair(passphrase)
+}
+
+// GenerateHostCert signs pub as a host certificate for hostname in
+// authDomain, using role to scope what the resulting node may do. The
+// cert is stamped with the next serial in the host CA's sequence so it
+// can later be revoked by RevokeCert.
+func (a *AuthServer) GenerateHostCert(pub []byte, hostname, authDomain string, role Role, ttl int64) ([]byte, error) {
+	priv, err := a.bk.GetVal(hostCAPath, caPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("host certificate authority is not initialized: %v", err)
+	}
+	serial, err := a.nextSerial(caHost)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := a.authority.GenerateHostCert(priv, pub, hostname, authDomain, serial, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.recordCertCA(serial, caHost); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// GenerateUserCert signs pub as a user certificate for username. The cert
+// is stamped with the next serial in the user CA's sequence so it can
+// later be revoked by RevokeCert.
+func (a *AuthServer) GenerateUserCert(pub []byte, username string, ttl int64) ([]byte, error) {
+	priv, err := a.bk.GetVal(userCAPath, caPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("user certificate authority is not initialized: %v", err)
+	}
+	serial, err := a.nextSerial(caUser)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := a.authority.GenerateUserCert(priv, pub, username, serial, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.recordCertCA(serial, caUser); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// UpsertPassword sets (or resets) user's password on the configured
+// PasswordBackend and, if that backend enrolls a teleport-managed second
+// factor, a fresh OTP generator and a set of backup codes alongside it.
+// url/qrImg/backupCodes are empty for backends (e.g. PAM) that bring
+// their own second factor; backupCodes are only ever returned here, so
+// the caller must show them to the user immediately.
+func (a *AuthServer) UpsertPassword(user string, password []byte, otpType services.OTPType) (url string, qrImg []byte, backupCodes []string, err error) {
+	if err := a.passwords.UpsertPassword(user, password); err != nil {
+		return "", nil, nil, err
+	}
+	if !a.passwords.SupportsHOTP() {
+		return "", nil, nil, nil
+	}
+	otp, err := generateOTP(otpType)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	codes, hashes, err := generateBackupCodes(backupCodeCount)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := a.upsertOTPState(user, services.OTPState{OTP: *otp, BackupCodeHashes: hashes}); err != nil {
+		return "", nil, nil, err
+	}
+	qr, err := otpQR(*otp, user)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return otpURL(*otp, user), qr, codes, nil
+}
+
+var otpPath = []string{"otp"}
+
+func (a *AuthServer) upsertOTPState(user string, state services.OTPState) error {
+	marshaled, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return a.bk.UpsertVal(otpPath, user, marshaled, 0)
+}
+
+func (a *AuthServer) getOTPState(user string) (*services.OTPState, error) {
+	marshaled, err := a.bk.GetVal(otpPath, user)
+	if err != nil {
+		return nil, err
+	}
+	var state services.OTPState
+	if err := json.Unmarshal(marshaled, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// CheckPasswordWOToken verifies a user's password without checking their
+// second factor; used when establishing the HOTP/TOTP challenge itself.
+func (a *AuthServer) CheckPasswordWOToken(user string, password []byte) error {
+	return a.passwords.CheckPassword(user, password)
+}
+
+// CheckPasswordWithHotpToken verifies a user's password and, for backends
+// that enroll one, their OTP token, advancing the stored HOTP counter on
+// success. token may also be one of the user's unused backup codes,
+// consumed on success so it cannot be reused. Backends that bring their
+// own second factor (PAM) skip this check entirely once the password
+// itself verifies. Repeated failures of either the password or the token
+// count against the user's lockout the same way they do in SignIn; the
+// lockout history is only cleared once both checks pass, so a correct
+// password alone does not buy unlimited guesses at the token.
+func (a *AuthServer) CheckPasswordWithHotpToken(user string, password []byte, token string) error {
+	if err := a.checkPasswordLocked(user, password); err != nil {
+		return err
+	}
+	if !a.passwords.SupportsHOTP() {
+		return a.registerSuccess(user)
+	}
+	state, err := a.getOTPState(user)
+	if err != nil {
+		if regErr := a.registerFailure(user); regErr != nil {
+			return regErr
+		}
+		return fmt.Errorf("invalid username or password")
+	}
+	if scanOTP(&state.OTP, token) {
+		if err := a.upsertOTPState(user, *state); err != nil {
+			return err
+		}
+		return a.registerSuccess(user)
+	}
+	if ok, remaining := consumeBackupCode(state.BackupCodeHashes, token); ok {
+		state.BackupCodeHashes = remaining
+		if err := a.upsertOTPState(user, *state); err != nil {
+			return err
+		}
+		return a.registerSuccess(user)
+	}
+	if regErr := a.registerFailure(user); regErr != nil {
+		return regErr
+	}
+	return fmt.Errorf("invalid token")
+}
+
+// SignIn verifies the user's password and, on success, issues a new web
+// session id. It does not re-check the HOTP/TOTP token: that was already
+// validated when the tunnel connection authenticated as RoleUser, and
+// SignIn is only reachable over such a connection. Repeated failures
+// lock the user out for lockoutDuration after maxFailedAttempts within
+// failureWindow; UnlockUser lifts a lockout early.
+func (a *AuthServer) SignIn(user string, password []byte) (string, error) {
+	if err := a.checkPasswordLocked(user, password); err != nil {
+		return "", err
+	}
+	if err := a.registerSuccess(user); err != nil {
+		return "", err
+	}
+	sid, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	if err := a.WebService.UpsertWebSession(user, sid, 0); err != nil {
+		return "", err
+	}
+	return sid, nil
+}
+
+// CreateSignupToken creates a new invite for user: a fresh OTP generator
+// of otpType and a random token that GetSignupTokenData/CreateUserWithToken
+// use to complete enrollment.
+func (a *AuthServer) CreateSignupToken(user string, otpType services.OTPType) (string, error) {
+	otp, err := generateOTP(otpType)
+	if err != nil {
+		return "", err
+	}
+	qr, err := otpQR(*otp, user)
+	if err != nil {
+		return "", err
+	}
+	token, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	data := services.SignupToken{User: user, OTP: *otp, QRImg: qr}
+	if err := a.WebService.UpsertSignupToken(token, data, signupTokenTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetSignupTokenData returns what the signup UI needs to render: the
+// invited username, the QR code image, and the OTP values listed by
+// acceptableCodes, so a client can advance an out-of-band generator to
+// match (used by tests that don't actually scan a QR code).
+func (a *AuthServer) GetSignupTokenData(token string) (user string, qrImg []byte, otpValues []string, e error) {
+	data, _, err := a.WebService.GetSignupToken(token)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return data.User, data.QRImg, acceptableCodes(data.OTP), nil
+}
+
+// acceptableCodes returns the codes otp would generate next: for HOTP,
+// the next six counter values, one more than scanOTP's window of five, so
+// the last code in the list is not accepted yet; for TOTP, the steps
+// within otp.Drift of now, all of which scanOTP accepts.
+func acceptableCodes(otp services.OTP) []string {
+	switch otp.Type {
+	case services.HOTP:
+		const window = 6
+		codes := make([]string, window)
+		for i := range codes {
+			codes[i] = codeAt(otp, otp.Counter+uint64(i))
+		}
+		return codes
+	case services.TOTP:
+		step := totpStep(otp)
+		codes := make([]string, 0, 2*otp.Drift+1)
+		for d := -otp.Drift; d <= otp.Drift; d++ {
+			codes = append(codes, codeAt(otp, uint64(int64(step)+int64(d))))
+		}
+		return codes
+	default:
+		return nil
+	}
+}
+
+// CreateUserWithToken completes a signup: it validates the token and, for
+// a PasswordBackend that supports HOTP, its OTP value, sets the user's
+// password, mints a set of backup codes (again only when HOTP is supported), and
+// marks the token used so it cannot be replayed (subsequent calls are
+// rejected once TokenTTLAfterUse elapses). A retry with the token
+// already marked used is accepted without re-checking the OTP value as
+// long as it carries the same password, so a flaky network doesn't
+// strand the user between their request succeeding and the response
+// reaching them; a retry with a different password is rejected. The
+// returned backup codes are empty on such a retry, since they were
+// already handed back once.
+func (a *AuthServer) CreateUserWithToken(token, password, otpToken string) (backupCodes []string, err error) {
+	data, _, err := a.WebService.GetSignupToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if data.Used {
+		if err := a.CheckPasswordWOToken(data.User, []byte(password)); err != nil {
+			return nil, fmt.Errorf("signup token already used")
+		}
+		return nil, nil
+	}
+	if a.passwords.SupportsHOTP() && !scanOTP(&data.OTP, otpToken) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if err := a.passwords.UpsertPassword(data.User, []byte(password)); err != nil {
+		return nil, err
+	}
+	if a.passwords.SupportsHOTP() {
+		codes, hashes, err := generateBackupCodes(backupCodeCount)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.upsertOTPState(data.User, services.OTPState{OTP: data.OTP, BackupCodeHashes: hashes}); err != nil {
+			return nil, err
+		}
+		backupCodes = codes
+	}
+	data.Used = true
+	if err := a.WebService.UpsertSignupToken(token, *data, TokenTTLAfterUse); err != nil {
+		return nil, err
+	}
+	return backupCodes, nil
+}
+
+// GetUsers returns the list of users with a password set in the default
+// bolt-backed PasswordBackend. Backends that manage their own user list
+// externally (htpasswd, PAM) are not reflected here.
+func (a *AuthServer) GetUsers() ([]string, error) {
+	return a.bk.GetKeys(passwordsPath)
+}
+
+// GetWebSession returns the session id previously issued to user, if it
+// matches sid.
+func (a *AuthServer) GetWebSession(user, sid string) (string, error) {
+	return a.WebService.GetWebSession(user, sid)
+}
+
+// DeleteWebSession revokes a previously issued web session.
+func (a *AuthServer) DeleteWebSession(user, sid string) error {
+	return a.WebService.DeleteWebSession(user, sid)
+}
+
+// UpsertServer registers (or refreshes) a node in the cluster inventory.
+func (a *AuthServer) UpsertServer(s services.Server, ttl time.Duration) error {
+	return a.bk.UpsertVal(serversPath, s.ID, []byte(s.Addr+"|"+s.Hostname), ttl)
+}
+
+var serversPath = []string{"servers"}
+
+// GetServers returns the cluster's node inventory.
+func (a *AuthServer) GetServers() ([]services.Server, error) {
+	ids, err := a.bk.GetKeys(serversPath)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]services.Server, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, services.Server{ID: id})
+	}
+	return out, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package htpasswd
+
+import (
+	"crypto/md5"
+	"strings"
+)
+
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1Crypt reproduces Apache's apr1 variant of the FreeBSD MD5-crypt
+// algorithm, reusing the salt out of an existing "$apr1$salt$digest"
+// hash so the result can be compared against it directly.
+func apr1Crypt(password []byte, hash string) string {
+	rest := strings.TrimPrefix(hash, "$apr1$")
+	salt := rest
+	if i := strings.IndexByte(rest, '$'); i >= 0 {
+		salt = rest[:i]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	return md5Crypt(password, []byte(salt), []byte("$apr1$"))
+}
+
+func md5Crypt(password, salt, magic []byte) string {
+	d := md5.New()
+	d.Write(password)
+	d.Write(magic)
+	d.Write(salt)
+
+	d2 := md5.New()
+	d2.Write(password)
+	d2.Write(salt)
+	d2.Write(password)
+	mixin := d2.Sum(nil)
+
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			d.Write(mixin)
+		} else {
+			d.Write(mixin[:i])
+		}
+	}
+
+	for i := len(password); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(password[:1])
+		}
+	}
+
+	final := d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.Write(magic)
+	out.Write(salt)
+	out.WriteByte('$')
+
+	triples := [5][3]byte{
+		{final[0], final[6], final[12]},
+		{final[1], final[7], final[13]},
+		{final[2], final[8], final[14]},
+		{final[3], final[9], final[15]},
+		{final[4], final[10], final[5]},
+	}
+	for _, t := range triples {
+		to64(&out, uint32(t[0])<<16|uint32(t[1])<<8|uint32(t[2]), 4)
+	}
+	to64(&out, uint32(final[11]), 2)
+
+	return out.String()
+}
+
+func to64(out *strings.Builder, v uint32, n int) {
+	for i := 0; i < n; i++ {
+		out.WriteByte(itoa64[v&0x3f])
+		v >>= 6
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gravitational/teleport/lib/services"
+)
+
+// maxFailedAttempts is how many consecutive password failures within
+// failureWindow lock a user out for lockoutDuration.
+const maxFailedAttempts = 5
+
+// failureWindow and lockoutDuration are vars (not consts) so tests can
+// shrink them, the same way TokenTTLAfterUse is.
+var (
+	failureWindow   = 15 * time.Minute
+	lockoutDuration = 15 * time.Minute
+)
+
+// backoffBase and backoffCap bound the exponential backoff TunServer
+// applies to repeated bad passwords from the same identity: 1s, 2s,
+// 4s, ... up to backoffCap.
+const (
+	backoffBase = time.Second
+	backoffCap  = 30 * time.Second
+)
+
+var loginAttemptsPath = []string{"login_attempts"}
+
+func (a *AuthServer) getLoginAttempts(user string) (services.LoginAttempts, error) {
+	marshaled, err := a.bk.GetVal(loginAttemptsPath, user)
+	if err != nil {
+		return services.LoginAttempts{}, nil
+	}
+	var attempts services.LoginAttempts
+	if err := json.Unmarshal(marshaled, &attempts); err != nil {
+		return services.LoginAttempts{}, err
+	}
+	return attempts, nil
+}
+
+func (a *AuthServer) upsertLoginAttempts(user string, attempts services.LoginAttempts) error {
+	marshaled, err := json.Marshal(attempts)
+	if err != nil {
+		return err
+	}
+	return a.bk.UpsertVal(loginAttemptsPath, user, marshaled, 0)
+}
+
+// checkLockout returns an error if user is currently locked out. A
+// lockout whose LockedUntil has already passed is treated as expired
+// (time-based auto-unlock) without needing a write here; the next
+// failure or success will overwrite the stale state.
+func (a *AuthServer) checkLockout(user string) error {
+	attempts, err := a.getLoginAttempts(user)
+	if err != nil {
+		return err
+	}
+	if !attempts.LockedUntil.IsZero() && time.Now().Before(attempts.LockedUntil) {
+		return fmt.Errorf("user %q is locked out until %v", user, attempts.LockedUntil)
+	}
+	return nil
+}
+
+// registerFailure records a password failure for user, resetting the
+// failure window if it has expired, and locks the account once
+// maxFailedAttempts have been seen within failureWindow. A lockout is
+// reported as an audit event via AuthServer's audit log, if configured.
+func (a *AuthServer) registerFailure(user string) error {
+	attempts, err := a.getLoginAttempts(user)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if attempts.FirstFailure.IsZero() || now.Sub(attempts.FirstFailure) > failureWindow {
+		attempts.FirstFailure = now
+		attempts.Count = 0
+	}
+	attempts.Count++
+	if attempts.Count >= maxFailedAttempts {
+		attempts.LockedUntil = now.Add(lockoutDuration)
+		if a.auditLog != nil {
+			a.auditLog.EmitAuditEvent("user.locked", map[string]interface{}{
+				"user":     user,
+				"attempts": attempts.Count,
+			})
+		}
+	}
+	return a.upsertLoginAttempts(user, attempts)
+}
+
+// registerSuccess clears any failed-attempt history for user. There is
+// nothing to clear for a user who has never failed, so a "not found"
+// from the backend is not an error here.
+func (a *AuthServer) registerSuccess(user string) error {
+	a.bk.DeleteKey(loginAttemptsPath, user)
+	return nil
+}
+
+// UnlockUser clears a lockout for user before lockoutDuration has
+// elapsed, for an admin who has independently verified the attempts
+// were legitimate.
+func (a *AuthServer) UnlockUser(user string) error {
+	return a.registerSuccess(user)
+}
+
+// checkPasswordLocked verifies password against user's stored password,
+// enforcing the per-user lockout around it: an already-locked account is
+// rejected without even checking the password, and each failure counts
+// against the lockout window. It does not clear the lockout history on
+// success: callers that also require a second factor (e.g.
+// CheckPasswordWithHotpToken) must not let a correct password alone
+// reset the attempt counter, or a stolen password would buy unlimited,
+// unthrottled guesses at the OTP token. Call registerSuccess once the
+// caller's full check has passed.
+func (a *AuthServer) checkPasswordLocked(user string, password []byte) error {
+	if err := a.checkLockout(user); err != nil {
+		return err
+	}
+	if err := a.CheckPasswordWOToken(user, password); err != nil {
+		if regErr := a.registerFailure(user); regErr != nil {
+			return regErr
+		}
+		return err
+	}
+	return nil
+}
+
+// backoffFor returns how long TunServer should delay user's next
+// attempt, given count consecutive recent failures.
+func backoffFor(count int) time.Duration {
+	if count <= 0 {
+		return 0
+	}
+	d := backoffBase << uint(count-1)
+	if d > backoffCap || d <= 0 {
+		return backoffCap
+	}
+	return d
+}
+
+// failedAttempts returns how many consecutive failures are currently on
+// record for user, for TunServer to compute its backoff delay from.
+func (a *AuthServer) failedAttempts(user string) (int, error) {
+	attempts, err := a.getLoginAttempts(user)
+	if err != nil {
+		return 0, err
+	}
+	if !attempts.FirstFailure.IsZero() && time.Now().Sub(attempts.FirstFailure) > failureWindow {
+		return 0, nil
+	}
+	return attempts.Count, nil
+}
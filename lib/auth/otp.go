@@ -0,0 +1,235 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gravitational/teleport/lib/services"
+
+	"github.com/gokyle/hotp"
+	"github.com/mdp/rsc/qr"
+)
+
+const otpDigits = 6
+
+// defaultTOTPPeriod and defaultTOTPDrift match what most authenticator
+// apps (Google Authenticator, Authy, ...) assume: a 30 second step, and
+// one step of tolerance either side for clock skew between client and
+// server.
+const (
+	defaultTOTPPeriod = 30
+	defaultTOTPDrift  = 1
+)
+
+// backupCodeCount is how many one-time backup codes are minted whenever
+// a user enrolls an OTP generator.
+const backupCodeCount = 10
+
+// generateOTP creates a fresh, unenrolled generator of otpType.
+func generateOTP(otpType services.OTPType) (*services.OTP, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	otp := &services.OTP{Type: otpType, Secret: key, Digits: otpDigits}
+	if otpType == services.TOTP {
+		otp.Period = defaultTOTPPeriod
+		otp.Drift = defaultTOTPDrift
+	}
+	return otp, nil
+}
+
+// otpURL returns the otpauth:// URL for otp, suitable for rendering as a
+// QR code for an authenticator app to scan. label is normally the
+// username.
+func otpURL(otp services.OTP, label string) string {
+	u := url.URL{Scheme: "otpauth", Host: string(otp.Type), Path: label}
+	v := url.Values{}
+	v.Set("secret", base32.StdEncoding.EncodeToString(otp.Secret))
+	v.Set("digits", fmt.Sprintf("%d", otp.Digits))
+	switch otp.Type {
+	case services.HOTP:
+		v.Set("counter", fmt.Sprintf("%d", otp.Counter))
+	case services.TOTP:
+		v.Set("period", fmt.Sprintf("%d", otp.Period))
+	}
+	u.RawQuery = v.Encode()
+	return u.String()
+}
+
+// parseOTPURL reverses otpURL, for clients (and tests) that need to
+// recover the enrolled OTP descriptor and label from it without scanning
+// a QR code.
+func parseOTPURL(raw string) (*services.OTP, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "otpauth" {
+		return nil, "", fmt.Errorf("invalid OTP url")
+	}
+	v := u.Query()
+	secret, err := base32.StdEncoding.DecodeString(v.Get("secret"))
+	if err != nil {
+		return nil, "", err
+	}
+	digits, err := strconv.Atoi(v.Get("digits"))
+	if err != nil {
+		return nil, "", err
+	}
+	otp := &services.OTP{Type: services.OTPType(u.Host), Secret: secret, Digits: digits}
+	switch otp.Type {
+	case services.HOTP:
+		counter, err := strconv.ParseUint(v.Get("counter"), 10, 64)
+		if err != nil {
+			return nil, "", err
+		}
+		otp.Counter = counter
+	case services.TOTP:
+		period, err := strconv.Atoi(v.Get("period"))
+		if err != nil {
+			return nil, "", err
+		}
+		otp.Period = period
+		otp.Drift = defaultTOTPDrift
+	default:
+		return nil, "", fmt.Errorf("unknown OTP type %q", otp.Type)
+	}
+	var label string
+	if len(u.Path) > 1 {
+		label = u.Path[1:]
+	}
+	return otp, label, nil
+}
+
+// otpQR renders otp's enrollment URL as a PNG QR code.
+func otpQR(otp services.OTP, label string) ([]byte, error) {
+	code, err := qr.Encode(otpURL(otp, label), qr.Q)
+	if err != nil {
+		return nil, err
+	}
+	return code.PNG(), nil
+}
+
+// codeAt returns the code otp would generate at counter: an explicit
+// HOTP counter value, or a TOTP time step.
+func codeAt(otp services.OTP, counter uint64) string {
+	return hotp.NewHOTP(otp.Secret, counter, otp.Digits).OTP()
+}
+
+// totpStep returns the current TOTP time step for otp.
+func totpStep(otp services.OTP) uint64 {
+	return uint64(time.Now().Unix()) / uint64(otp.Period)
+}
+
+// scanOTP checks code against otp: a window of HOTP counter values ahead
+// of the stored one (to tolerate the server and an authenticator app
+// falling out of sync), or the TOTP steps within otp.Drift of now. On a
+// HOTP match, otp.Counter is advanced past the value that matched so it
+// can't be replayed; TOTP has no counter to advance.
+func scanOTP(otp *services.OTP, code string) bool {
+	codeBytes := []byte(code)
+	switch otp.Type {
+	case services.HOTP:
+		const window = 5
+		for i := 0; i < window; i++ {
+			counter := otp.Counter + uint64(i)
+			if subtle.ConstantTimeCompare(codeBytes, []byte(codeAt(*otp, counter))) == 1 {
+				otp.Counter = counter + 1
+				return true
+			}
+		}
+		return false
+	case services.TOTP:
+		step := totpStep(*otp)
+		for d := -otp.Drift; d <= otp.Drift; d++ {
+			counter := uint64(int64(step) + int64(d))
+			if subtle.ConstantTimeCompare(codeBytes, []byte(codeAt(*otp, counter))) == 1 {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// backupCodeSaltLen is the size of the random salt prefixed to each
+// backup code hash, so that two users who happen to draw the same code
+// don't persist the same hash.
+const backupCodeSaltLen = 16
+
+// generateBackupCodes mints n random backup codes, returning them in the
+// clear (to show the user once) alongside the salted hashes that
+// actually get persisted.
+func generateBackupCodes(n int) (codes []string, hashes [][]byte, err error) {
+	for i := 0; i < n; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+		hash, err := hashBackupCode(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+// hashBackupCode returns a fresh random salt and sha256(salt || code),
+// concatenated so the pair round-trips through the single []byte
+// services.OTPState persists per code.
+func hashBackupCode(code string) ([]byte, error) {
+	salt := make([]byte, backupCodeSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return saltedBackupCodeHash(salt, code), nil
+}
+
+func saltedBackupCodeHash(salt []byte, code string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), code...))
+	return append(append([]byte{}, salt...), sum[:]...)
+}
+
+// consumeBackupCode reports whether code matches one of hashes. On a
+// match it returns the remaining, still-unused hashes with that one
+// removed so it can't be replayed.
+func consumeBackupCode(hashes [][]byte, code string) (ok bool, remaining [][]byte) {
+	for i, h := range hashes {
+		if len(h) < backupCodeSaltLen {
+			continue
+		}
+		target := saltedBackupCodeHash(h[:backupCodeSaltLen], code)
+		if subtle.ConstantTimeCompare(h, target) == 1 {
+			remaining = append(append([][]byte{}, hashes[:i]...), hashes[i+1:]...)
+			return true, remaining
+		}
+	}
+	return false, hashes
+}
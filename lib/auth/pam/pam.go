@@ -0,0 +1,124 @@
+//go:build linux && pam_backend
+
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pam implements an auth.PasswordBackend backed by libpam, so
+// teleport can authenticate against whatever PAM stack the host already
+// has configured (system passwd, LDAP, Duo, ...) instead of importing
+// accounts into its own store. It requires cgo and a libpam-dev install,
+// so it only builds when explicitly opted into with the pam_backend
+// build tag (e.g. `go build -tags pam_backend`); see pam_unsupported.go
+// for the stub built by default, including on Linux.
+package pam
+
+/*
+#cgo LDFLAGS: -lpam
+#include <stdlib.h>
+#include <string.h>
+#include <security/pam_appl.h>
+
+static int teleport_pam_conv(int num_msg, const struct pam_message **msg,
+                              struct pam_response **resp, void *appdata_ptr) {
+	struct pam_response *reply = calloc(num_msg, sizeof(struct pam_response));
+	if (reply == NULL) {
+		return PAM_BUF_ERR;
+	}
+	for (int i = 0; i < num_msg; i++) {
+		switch (msg[i]->msg_style) {
+		case PAM_PROMPT_ECHO_OFF:
+		case PAM_PROMPT_ECHO_ON:
+			reply[i].resp = strdup((const char *)appdata_ptr);
+			reply[i].resp_retcode = 0;
+			break;
+		default:
+			reply[i].resp = NULL;
+			reply[i].resp_retcode = 0;
+			break;
+		}
+	}
+	*resp = reply;
+	return PAM_SUCCESS;
+}
+
+static struct pam_conv teleport_pam_make_conv(char *password) {
+	struct pam_conv conv;
+	conv.conv = teleport_pam_conv;
+	conv.appdata_ptr = password;
+	return conv;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Backend is an auth.PasswordBackend that authenticates users through
+// PAM's pam_authenticate/pam_acct_mgmt, using the named PAM service. It
+// is read-only: PAM accounts are managed by the host, not teleport.
+type Backend struct {
+	service string
+}
+
+// New returns a Backend authenticating against the named PAM service
+// (e.g. "sshd", "login", or a teleport-specific service the operator
+// has configured under /etc/pam.d).
+func New(service string) *Backend {
+	return &Backend{service: service}
+}
+
+// UpsertPassword always fails: PAM accounts are managed by the host, not
+// teleport.
+func (b *Backend) UpsertPassword(user string, password []byte) error {
+	return fmt.Errorf("PAM backend is read-only; manage %q through the system's user database instead", user)
+}
+
+// CheckPassword verifies password for user by running PAM's
+// authenticate and account-validity checks against b.service.
+func (b *Backend) CheckPassword(user string, password []byte) error {
+	cService := C.CString(b.service)
+	defer C.free(unsafe.Pointer(cService))
+	cUser := C.CString(user)
+	defer C.free(unsafe.Pointer(cUser))
+	cPassword := C.CString(string(password))
+	defer C.free(unsafe.Pointer(cPassword))
+
+	conv := C.teleport_pam_make_conv(cPassword)
+
+	var pamh *C.pam_handle_t
+	if rc := C.pam_start(cService, cUser, &conv, &pamh); rc != C.PAM_SUCCESS {
+		return fmt.Errorf("pam_start failed: %d", int(rc))
+	}
+	defer C.pam_end(pamh, C.PAM_SUCCESS)
+
+	if rc := C.pam_authenticate(pamh, 0); rc != C.PAM_SUCCESS {
+		return fmt.Errorf("invalid username or password")
+	}
+	if rc := C.pam_acct_mgmt(pamh, 0); rc != C.PAM_SUCCESS {
+		return fmt.Errorf("invalid username or password")
+	}
+	return nil
+}
+
+// SupportsHOTP reports false: PAM users typically bring their own second
+// factor (pam_duo, pam_google_authenticator, ...) configured inside
+// b.service, so AuthServer skips teleport-managed HOTP/TOTP enrollment
+// and verification for this backend.
+func (b *Backend) SupportsHOTP() bool {
+	return false
+}
@@ -0,0 +1,49 @@
+//go:build !linux || !pam_backend
+
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pam
+
+import "fmt"
+
+// Backend is a stub standing in for the libpam-backed implementation,
+// built whenever cgo's libpam dependency hasn't been opted into with the
+// pam_backend build tag (the default, even on Linux) or the platform
+// isn't Linux at all.
+type Backend struct{}
+
+// New returns a Backend whose methods all report that PAM authentication
+// isn't supported on this platform.
+func New(service string) *Backend {
+	return &Backend{}
+}
+
+// UpsertPassword always fails: this build has no libpam support.
+func (b *Backend) UpsertPassword(user string, password []byte) error {
+	return fmt.Errorf("PAM authentication is not supported on this platform")
+}
+
+// CheckPassword always fails: this build has no libpam support.
+func (b *Backend) CheckPassword(user string, password []byte) error {
+	return fmt.Errorf("PAM authentication is not supported on this platform")
+}
+
+// SupportsHOTP reports false: there is no backend here to enroll a
+// second factor against.
+func (b *Backend) SupportsHOTP() bool {
+	return false
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"fmt"
+
+	"github.com/gravitational/teleport/lib/backend"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordBackend stores and verifies user passwords, independent of the
+// HOTP/TOTP second factor and session state, which always live in
+// AuthServer's own backend. Swapping in a different PasswordBackend lets
+// teleport check credentials against an existing htpasswd file or PAM
+// rather than importing every account into its own store.
+type PasswordBackend interface {
+	// UpsertPassword sets (or resets) user's password. Read-only backends
+	// (htpasswd, PAM) return an error: passwords there are managed
+	// externally, not by teleport.
+	UpsertPassword(user string, password []byte) error
+	// CheckPassword verifies password for user.
+	CheckPassword(user string, password []byte) error
+	// SupportsHOTP reports whether users of this backend enroll a
+	// teleport-managed HOTP/TOTP second factor. Backends that delegate
+	// second-factor elsewhere (PAM) return false, and AuthServer skips
+	// HOTP enrollment and verification entirely for them.
+	SupportsHOTP() bool
+}
+
+var passwordsPath = []string{"passwords"}
+
+// boltPasswordBackend is the default PasswordBackend: bcrypt hashes
+// stored directly in the auth server's own backend. It is what
+// NewAuthServer uses unless WithPasswordBackend overrides it.
+type boltPasswordBackend struct {
+	bk backend.Backend
+}
+
+func newBoltPasswordBackend(bk backend.Backend) PasswordBackend {
+	return &boltPasswordBackend{bk: bk}
+}
+
+func (b *boltPasswordBackend) UpsertPassword(user string, password []byte) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return b.bk.UpsertVal(passwordsPath, user, hash, 0)
+}
+
+func (b *boltPasswordBackend) CheckPassword(user string, password []byte) error {
+	hash, err := b.bk.GetVal(passwordsPath, user)
+	if err != nil {
+		return fmt.Errorf("invalid username or password")
+	}
+	if !checkPasswordHash(password, hash) {
+		return fmt.Errorf("invalid username or password")
+	}
+	return nil
+}
+
+func (b *boltPasswordBackend) SupportsHOTP() bool {
+	return true
+}
+
+// hashPassword bcrypt-hashes password. bcrypt also rejects passwords
+// over 72 bytes with bcrypt.ErrPasswordTooLong, which is a condition a
+// caller choosing its own password can trigger, so that error is
+// returned rather than panicking.
+func hashPassword(password []byte) ([]byte, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password: %v", err)
+	}
+	return hash, nil
+}
+
+func checkPasswordHash(password, hash []byte) bool {
+	return bcrypt.CompareHashAndPassword(hash, password) == nil
+}
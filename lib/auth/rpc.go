@@ -0,0 +1,127 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"time"
+
+	"github.com/gravitational/teleport/lib/services"
+)
+
+// credentials is carried, base64(JSON)-encoded, as the "password" of the
+// ssh.AuthMethod returned by the New*Auth constructors below. The tunnel
+// server decodes it in its PasswordCallback to figure out both who is
+// connecting and which Role they get to act as for the lifetime of the
+// connection.
+type credentials struct {
+	Role      Role   `json:"role"`
+	User      string `json:"user,omitempty"`
+	Password  string `json:"password,omitempty"`
+	HotpToken string `json:"hotp_token,omitempty"`
+	Sid       string `json:"sid,omitempty"`
+	Token     string `json:"token,omitempty"`
+	// PubKey is the public key a RoleSSOCallback connection wants signed
+	// into a user cert once its identity has been validated.
+	PubKey []byte `json:"pub_key,omitempty"`
+}
+
+// Every RPC the tunnel exposes is a named ssh global request; the
+// payload and reply are JSON-encoded argument/result structs below.
+const (
+	reqUpsertServer        = "UpsertServer"
+	reqGetServers          = "GetServers"
+	reqGetUsers            = "GetUsers"
+	reqSignIn              = "SignIn"
+	reqGetWebSession       = "GetWebSession"
+	reqDeleteWebSession    = "DeleteWebSession"
+	reqGetSignupTokenData  = "GetSignupTokenData"
+	reqCreateUserWithToken = "CreateUserWithToken"
+	reqGetSSOSession       = "GetSSOSession"
+	reqGetKRL              = "GetKRL"
+	reqUnlockUser          = "UnlockUser"
+)
+
+type upsertServerReq struct {
+	Server services.Server `json:"server"`
+	TTL    time.Duration   `json:"ttl"`
+}
+
+type getServersReply struct {
+	Servers []services.Server `json:"servers"`
+}
+
+type getUsersReply struct {
+	Users []string `json:"users"`
+}
+
+type signInReq struct {
+	User     string `json:"user"`
+	Password []byte `json:"password"`
+}
+
+type signInReply struct {
+	SID string `json:"sid"`
+}
+
+type webSessionReq struct {
+	User string `json:"user"`
+	SID  string `json:"sid"`
+}
+
+type webSessionReply struct {
+	SID string `json:"sid"`
+}
+
+type signupTokenDataReq struct {
+	Token string `json:"token"`
+}
+
+type signupTokenDataReply struct {
+	User      string   `json:"user"`
+	QRImg     []byte   `json:"qr_img"`
+	OTPValues []string `json:"otp_values"`
+}
+
+type createUserWithTokenReq struct {
+	Token     string `json:"token"`
+	Password  string `json:"password"`
+	HotpToken string `json:"hotp_token"`
+}
+
+type createUserWithTokenReply struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
+type getKRLReply struct {
+	KRL []byte `json:"krl"`
+}
+
+type ssoSessionReply struct {
+	SID  string `json:"sid"`
+	Cert []byte `json:"cert"`
+}
+
+type unlockUserReq struct {
+	User string `json:"user"`
+}
+
+// errReply carries an RPC failure back to the client; a request without
+// an error is assumed to have succeeded and carries its reply struct
+// instead.
+type errReply struct {
+	Error string `json:"error"`
+}
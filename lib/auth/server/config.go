@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/gravitational/teleport/lib/limiter"
+	"github.com/gravitational/teleport/lib/utils"
+)
+
+// fileConfig is the JSON-serializable subset of Config: the plain
+// values an operator can set without writing Go. Config fields that
+// take live implementations (Authority, PasswordBackend, Permissions,
+// SealKeyFunc) aren't representable here and must be set on the
+// returned Config directly, in code, before calling Run.
+type fileConfig struct {
+	DataDir          string `json:"data_dir"`
+	ListenAddr       string `json:"listen_addr"`
+	DomainName       string `json:"domain_name"`
+	HostCAPassphrase string `json:"host_ca_passphrase,omitempty"`
+	MaxConnections   int64  `json:"max_connections_per_ip,omitempty"`
+}
+
+// LoadConfigFile reads a JSON config file from path into a Config.
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fc fileConfig
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, err
+	}
+	return fc.toConfig()
+}
+
+// Environment variables ApplyEnv overrides Config fields with, taking
+// precedence over whatever a config file set.
+const (
+	EnvDataDir          = "TELEPORT_DATA_DIR"
+	EnvListenAddr       = "TELEPORT_LISTEN_ADDR"
+	EnvDomainName       = "TELEPORT_DOMAIN_NAME"
+	EnvHostCAPassphrase = "TELEPORT_HOST_CA_PASSPHRASE"
+)
+
+// ApplyEnv overrides any of DataDir, ListenAddr, DomainName and
+// HostCAPassphrase that have a corresponding TELEPORT_* environment
+// variable set, so operators can tweak a file-based config without
+// editing it (e.g. in a container).
+func (c *Config) ApplyEnv() error {
+	if v := os.Getenv(EnvDataDir); v != "" {
+		c.DataDir = v
+	}
+	if v := os.Getenv(EnvListenAddr); v != "" {
+		addr, err := utils.ParseAddr(v)
+		if err != nil {
+			return err
+		}
+		c.ListenAddr = addr
+	}
+	if v := os.Getenv(EnvDomainName); v != "" {
+		c.DomainName = v
+	}
+	if v := os.Getenv(EnvHostCAPassphrase); v != "" {
+		c.HostCAPassphrase = v
+	}
+	return nil
+}
+
+func (fc *fileConfig) toConfig() (*Config, error) {
+	cfg := &Config{
+		DataDir:          fc.DataDir,
+		DomainName:       fc.DomainName,
+		HostCAPassphrase: fc.HostCAPassphrase,
+		Limiter:          limiter.LimiterConfig{MaxConnections: fc.MaxConnections},
+	}
+	if fc.ListenAddr != "" {
+		addr, err := utils.ParseAddr(fc.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ListenAddr = addr
+	}
+	return cfg, nil
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package server assembles an auth.AuthServer, its APIWithRoles and its
+// tunnel into a single runnable auth node from a declarative Config,
+// so that tests and the teleport binary don't each have to duplicate
+// the wiring by hand.
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/gravitational/teleport/lib/auth"
+	"github.com/gravitational/teleport/lib/auth/testauthority"
+	"github.com/gravitational/teleport/lib/backend"
+	"github.com/gravitational/teleport/lib/backend/boltbk"
+	"github.com/gravitational/teleport/lib/backend/encryptedbk"
+	"github.com/gravitational/teleport/lib/backend/encryptedbk/encryptor"
+	"github.com/gravitational/teleport/lib/events/boltlog"
+	"github.com/gravitational/teleport/lib/limiter"
+	"github.com/gravitational/teleport/lib/recorder"
+	"github.com/gravitational/teleport/lib/recorder/boltrec"
+	"github.com/gravitational/teleport/lib/session"
+	"github.com/gravitational/teleport/lib/sshutils"
+	"github.com/gravitational/teleport/lib/utils"
+
+	"github.com/mailgun/lemma/secret"
+	"golang.org/x/crypto/ssh"
+)
+
+// Config declaratively describes everything needed to stand up an auth
+// node: where it persists state, where it listens, and which policies
+// govern it. The zero value is not valid; see Validate.
+type Config struct {
+	// DataDir holds this node's bolt databases (backend, event log,
+	// session recordings) and its backend encryption keys.
+	DataDir string
+	// ListenAddr is where the tunnel accepts RPCs from nodes, proxies
+	// and clients.
+	ListenAddr utils.NetAddr
+	// DomainName identifies this cluster in certificates it issues, and
+	// is also used as the host certificate's principal.
+	DomainName string
+	// HostCAPassphrase protects the host CA private key at rest, if the
+	// backend supports it.
+	HostCAPassphrase string
+
+	// Roles lists which Roles the tunnel resolves client credentials to
+	// and enforces permissions for; defaults to auth.StandardRoles.
+	Roles []auth.Role
+	// Permissions overrides the permission matrix enforced for Roles;
+	// defaults to auth.NewStandardPermissions().
+	Permissions auth.Permissions
+	// PasswordBackend overrides how user passwords are verified; see
+	// auth.WithPasswordBackend. Defaults to the backend-native store.
+	PasswordBackend auth.PasswordBackend
+	// Authority signs the host and user certificate authorities. There
+	// is no production implementation in this tree yet; a real
+	// deployment must supply a hardware- or software-backed one here.
+	// Defaults to testauthority.Keygen, which is not safe for production.
+	Authority auth.CertAuthority
+	// SealKeyFunc supplies the key the backend uses to encrypt values at
+	// rest; see encryptedbk.NewReplicatedBackend. Defaults to
+	// encryptor.GetOrCreateKey loading from (or generating into)
+	// DataDir/keys, so a restarted node can still decrypt what it wrote
+	// before going down.
+	SealKeyFunc func() (*[secret.SecretKeyLength]byte, error)
+
+	// Limiter is the per-source-IP configuration handed to the tunnel's
+	// limiter.Limiter; see that type for which limits it enforces.
+	Limiter limiter.LimiterConfig
+}
+
+// Validate checks that Config has enough set to start an auth node.
+func (c *Config) Validate() error {
+	if c.DataDir == "" {
+		return fmt.Errorf("DataDir is required")
+	}
+	if c.DomainName == "" {
+		return fmt.Errorf("DomainName is required")
+	}
+	if c.ListenAddr.IsEmpty() {
+		return fmt.Errorf("ListenAddr is required")
+	}
+	return nil
+}
+
+// Server is a running auth node: an AuthServer, its APIWithRoles, and
+// the tunnel dispatching RPCs to it, plus the resources they share.
+type Server struct {
+	cfg  Config
+	bk   backend.Backend
+	bl   *boltlog.BoltLog
+	rec  recorder.Recorder
+	auth *auth.AuthServer
+	api  *auth.APIWithRoles
+	tun  *auth.TunServer
+}
+
+// Run assembles an auth node from cfg and starts its tunnel listening.
+func Run(cfg Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	baseBk, err := boltbk.New(filepath.Join(cfg.DataDir, "db"))
+	if err != nil {
+		return nil, err
+	}
+	keysDir := filepath.Join(cfg.DataDir, "keys")
+	sealKeyFunc := cfg.SealKeyFunc
+	if sealKeyFunc == nil {
+		sealKeyFunc = func() (*[secret.SecretKeyLength]byte, error) {
+			return encryptor.GetOrCreateKey(keysDir)
+		}
+	}
+	bk, err := encryptedbk.NewReplicatedBackend(baseBk, keysDir, nil, sealKeyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	bl, err := boltlog.New(filepath.Join(cfg.DataDir, "events"))
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := boltrec.New(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	authority := cfg.Authority
+	if authority == nil {
+		authority = testauthority.New()
+	}
+
+	scrtKey, err := secret.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	scrt, err := secret.New(&secret.Config{KeyBytes: scrtKey})
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []auth.AuthServerOption
+	if cfg.PasswordBackend != nil {
+		opts = append(opts, auth.WithPasswordBackend(cfg.PasswordBackend))
+	}
+	opts = append(opts, auth.WithAuditLog(bl))
+	authSrv := auth.NewAuthServer(bk, authority, scrt, cfg.DomainName, opts...)
+
+	if err := authSrv.EnsureHostCertificateAuthority(cfg.HostCAPassphrase); err != nil {
+		return nil, err
+	}
+	hpriv, hpub, err := authSrv.GenerateKeyPair("")
+	if err != nil {
+		return nil, err
+	}
+	hcert, err := authSrv.GenerateHostCert(hpub, cfg.DomainName, cfg.DomainName, auth.RoleNode, 0)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := sshutils.NewSigner(hpriv, hcert)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := cfg.Permissions
+	if permissions == nil {
+		permissions = auth.NewStandardPermissions()
+	}
+	roles := cfg.Roles
+	if roles == nil {
+		roles = auth.StandardRoles
+	}
+	api := auth.NewAPIWithRoles(authSrv, bl, session.New(bk), rec, permissions, roles)
+	api.Serve()
+
+	l, err := limiter.NewLimiter(cfg.Limiter)
+	if err != nil {
+		return nil, err
+	}
+	tun, err := auth.NewTunServer(cfg.ListenAddr, []ssh.Signer{signer}, api, authSrv, l)
+	if err != nil {
+		return nil, err
+	}
+	if err := tun.Start(); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		cfg:  cfg,
+		bk:   bk,
+		bl:   bl,
+		rec:  rec,
+		auth: authSrv,
+		api:  api,
+		tun:  tun,
+	}, nil
+}
+
+// Addr returns the address the tunnel is actually listening on, useful
+// when Config.ListenAddr used a ":0" port.
+func (s *Server) Addr() string {
+	return s.tun.Addr()
+}
+
+// AuthServer returns the underlying auth server, for callers (like the
+// teleport CLI) that need to act on it directly rather than over the
+// tunnel, e.g. to create a signup token.
+func (s *Server) AuthServer() *auth.AuthServer {
+	return s.auth
+}
+
+// Shutdown stops the tunnel from accepting new connections, waits for
+// its already accepted connections to be closed (or ctx to expire), and
+// then releases every resource Run acquired.
+func (s *Server) Shutdown(ctx context.Context) error {
+	drainErr := s.tun.Shutdown(ctx)
+	if err := s.api.Close(); err != nil && drainErr == nil {
+		drainErr = err
+	}
+	if err := s.rec.Close(); err != nil && drainErr == nil {
+		drainErr = err
+	}
+	if err := s.bl.Close(); err != nil && drainErr == nil {
+		drainErr = err
+	}
+	if err := s.bk.Close(); err != nil && drainErr == nil {
+		drainErr = err
+	}
+	return drainErr
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Identity is what a SSOConnector hands back once a user has completed
+// the identity provider's login flow: the claims it trusts enough to map
+// onto a Teleport user.
+type Identity struct {
+	// ConnectorID identifies which SSOConnector produced this identity.
+	ConnectorID string `json:"connector_id"`
+	// Email is the verified email address of the authenticated user.
+	Email string `json:"email"`
+	// Username is the Teleport username this identity maps to, after
+	// applying the connector's Claims -> TeleportUser mapping.
+	Username string `json:"username"`
+}
+
+// SSOConnector drives a single OAuth2/OIDC identity provider (Google,
+// GitHub, GitLab, or a generic OIDC issuer) through the two steps every
+// such flow needs: building the redirect URL, and exchanging the
+// resulting callback for a verified Identity.
+type SSOConnector interface {
+	// ID returns the connector's unique name, e.g. "google" or "acme-okta".
+	ID() string
+	// GetAuthURL returns the identity provider's login URL to redirect
+	// the user to, embedding state so the callback can be matched back
+	// to the request that started it.
+	GetAuthURL(state string) string
+	// ValidateCallback exchanges the code/state pair the identity
+	// provider redirected back with for a verified Identity.
+	ValidateCallback(code, state string) (*Identity, error)
+}
+
+// SSOAuthRequest is the server-side record of an in-flight SSO login: it
+// remembers which connector was used and the state value handed to the
+// identity provider, so ValidateSSOCallback can match the callback back
+// to it and reject replays or state mismatches.
+type SSOAuthRequest struct {
+	ConnectorID string    `json:"connector_id"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const ssoAuthRequestTTL = 10 * time.Minute
+
+var ssoConnectorsPath = []string{"sso", "connectors"}
+var ssoRequestsPath = []string{"sso", "requests"}
+
+// SSOConnectorConfig is the declarative, persisted form of an
+// SSOConnector's settings: enough to rebuild the concrete connector for
+// identity-provider Type ("google", "github", "gitlab", "oidc", ...),
+// without the auth package needing to know how to talk to any particular
+// provider itself. That's left to whatever constructs a connector from
+// the configs GetSSOConnectorConfigs returns, the same way Config.Authority
+// and Config.SealKeyFunc are supplied by the caller in package server.
+type SSOConnectorConfig struct {
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	IssuerURL    string   `json:"issuer_url,omitempty"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// connectors holds the registered SSOConnector instances for this
+// process, keyed by ID. Connector configuration itself lives in the
+// encrypted backend (see RegisterSSOConnector/GetSSOConnectorConfigs);
+// this in-memory map is updated alongside it by RegisterSSOConnector and
+// UnregisterSSOConnector, and is never loaded from the backend, so it
+// starts empty in a new process. mu guards byID, since registration/unregistration
+// is an ordinary runtime admin action that can race an in-flight
+// CreateSSOAuthRequest/ValidateSSOCallback.
+type connectorRegistry struct {
+	mu   sync.Mutex
+	byID map[string]SSOConnector
+}
+
+func newConnectorRegistry() *connectorRegistry {
+	return &connectorRegistry{byID: make(map[string]SSOConnector)}
+}
+
+func (r *connectorRegistry) get(id string) (SSOConnector, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	connector, ok := r.byID[id]
+	return connector, ok
+}
+
+func (r *connectorRegistry) set(id string, connector SSOConnector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID[id] = connector
+}
+
+func (r *connectorRegistry) delete(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byID, id)
+}
+
+// RegisterSSOConnector persists cfg to the encrypted backend and makes
+// connector available for CreateSSOAuthRequest/ValidateSSOCallback to
+// use. Calling it again with the same ID replaces both the persisted
+// config and the in-memory connector, so config reloads and runtime
+// connector changes don't require a restart.
+func (a *AuthServer) RegisterSSOConnector(cfg SSOConnectorConfig, connector SSOConnector) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := a.bk.UpsertVal(ssoConnectorsPath, cfg.ID, data, 0); err != nil {
+		return err
+	}
+	a.connectors.set(cfg.ID, connector)
+	return nil
+}
+
+// UnregisterSSOConnector removes a previously registered connector by id,
+// from both the in-memory registry and the persisted backend.
+func (a *AuthServer) UnregisterSSOConnector(id string) error {
+	a.connectors.delete(id)
+	return a.bk.DeleteKey(ssoConnectorsPath, id)
+}
+
+// GetSSOConnectorConfigs returns every SSO connector config persisted in
+// the backend, for a caller (typically on startup) to rebuild real
+// connectors from and re-register with RegisterSSOConnector.
+func (a *AuthServer) GetSSOConnectorConfigs() ([]SSOConnectorConfig, error) {
+	ids, err := a.bk.GetKeys(ssoConnectorsPath)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]SSOConnectorConfig, 0, len(ids))
+	for _, id := range ids {
+		data, err := a.bk.GetVal(ssoConnectorsPath, id)
+		if err != nil {
+			continue
+		}
+		var cfg SSOConnectorConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// CreateSSOAuthRequest starts an SSO login against the named connector,
+// returning the URL the caller should redirect the user to. The request
+// is persisted with a short TTL so ValidateSSOCallback can look it up
+// once the identity provider redirects back.
+func (a *AuthServer) CreateSSOAuthRequest(connectorID string) (redirectURL string, err error) {
+	connector, ok := a.connectors.get(connectorID)
+	if !ok {
+		return "", fmt.Errorf("no SSO connector registered with id %q", connectorID)
+	}
+	state, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	req := SSOAuthRequest{ConnectorID: connectorID, State: state, CreatedAt: time.Now()}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	if err := a.bk.UpsertVal(ssoRequestsPath, state, data, ssoAuthRequestTTL); err != nil {
+		return "", err
+	}
+	return connector.GetAuthURL(state), nil
+}
+
+// ValidateSSOCallback completes an SSO login: it looks up the request
+// that state was issued for, exchanges code with the matching connector,
+// maps the resulting Identity onto a Teleport user, issues a new web
+// session for it exactly as SignIn does for password logins, and signs
+// pubKey into a user cert so the client can reach nodes the same way a
+// password+OTP login's cert-based follow-up connection would. Both are
+// created with a ttl of 0: the web session gets no expiry, and the cert
+// gets whatever the CertAuthority issues for ttl 0 (testauthority.Keygen
+// issues it without an expiry).
+func (a *AuthServer) ValidateSSOCallback(code, state string, pubKey []byte) (sid string, cert []byte, err error) {
+	data, err := a.bk.GetVal(ssoRequestsPath, state)
+	if err != nil {
+		return "", nil, fmt.Errorf("SSO auth request expired or not found")
+	}
+	var req SSOAuthRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return "", nil, err
+	}
+	// The request is single-use: whether ValidateCallback below succeeds
+	// or not, the same state must not be replayable.
+	a.bk.DeleteKey(ssoRequestsPath, state)
+
+	connector, ok := a.connectors.get(req.ConnectorID)
+	if !ok {
+		return "", nil, fmt.Errorf("no SSO connector registered with id %q", req.ConnectorID)
+	}
+	identity, err := connector.ValidateCallback(code, state)
+	if err != nil {
+		return "", nil, err
+	}
+	if identity.Username == "" {
+		return "", nil, fmt.Errorf("connector %q did not map %q to a Teleport user", req.ConnectorID, identity.Email)
+	}
+	sid, err = newSessionID()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := a.WebService.UpsertWebSession(identity.Username, sid, 0); err != nil {
+		return "", nil, err
+	}
+	cert, err = a.GenerateUserCert(pubKey, identity.Username, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	return sid, cert, nil
+}
+
+// NewSSOAuth returns an AuthMethod that authenticates as RoleSSOCallback
+// using an OIDC ID token and the state value from CreateSSOAuthRequest,
+// presenting pubKey for ValidateSSOCallback to sign into a user cert.
+// The tunnel server trades it for a web session and that cert during the
+// handshake, exactly like the signup-token flow trades a token for a
+// session.
+func NewSSOAuth(idToken, state string, pubKey []byte) (ssh.AuthMethod, error) {
+	return credentialsAuth(credentials{
+		Role:     RoleSSOCallback,
+		Password: idToken,
+		Sid:      state,
+		PubKey:   pubKey,
+	})
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testauthority provides a fast, insecure certificate authority
+// implementation for use in tests only.
+package testauthority
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Keygen is a test-only implementation of the auth.CertAuthority
+// interface. It generates small, fast RSA keys and signs certificates
+// without any of the safeguards (hardware backing, audit logging) a
+// production CA would have.
+type Keygen struct{}
+
+// New returns a fresh test keygen.
+func New() *Keygen {
+	return &Keygen{}
+}
+
+// GenerateKeyPair returns a new 1024-bit RSA key pair, PEM-encoded; the
+// passphrase is ignored. 1024 bits would be unacceptably weak in
+// production but keeps tests fast.
+func (k *Keygen) GenerateKeyPair(passphrase string) (priv []byte, pub []byte, err error) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, nil, err
+	}
+	privPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	})
+	sshPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privPEM, ssh.MarshalAuthorizedKey(sshPub), nil
+}
+
+// GenerateHostCert signs a host certificate over pub using caPriv.
+func (k *Keygen) GenerateHostCert(caPriv []byte, pub []byte, hostname, authDomain string, serial uint64, ttl int64) ([]byte, error) {
+	return signCertificate(caPriv, pub, ssh.HostCert, []string{hostname}, serial, ttl)
+}
+
+// GenerateUserCert signs a user certificate over pub using caPriv.
+func (k *Keygen) GenerateUserCert(caPriv []byte, pub []byte, username string, serial uint64, ttl int64) ([]byte, error) {
+	return signCertificate(caPriv, pub, ssh.UserCert, []string{username}, serial, ttl)
+}
+
+func signCertificate(caPriv []byte, pub []byte, certType uint32, principals []string, serial uint64, ttl int64) ([]byte, error) {
+	caSigner, err := ssh.ParsePrivateKey(caPriv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA private key: %v", err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	validBefore := uint64(ssh.CertTimeInfinity)
+	if ttl > 0 {
+		validBefore = uint64(time.Now().Add(time.Duration(ttl)).Unix())
+	}
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		Serial:          serial,
+		CertType:        certType,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     validBefore,
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	return ssh.MarshalAuthorizedKey(cert), nil
+}
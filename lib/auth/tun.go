@@ -0,0 +1,444 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gravitational/teleport/lib/limiter"
+	"github.com/gravitational/teleport/lib/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// krlRefreshInterval is how often a running TunServer re-fetches the KRL
+// it enforces cert revocation against, so a cert revoked after the
+// server started still gets rejected without a restart.
+const krlRefreshInterval = 30 * time.Second
+
+// TunServer accepts SSH connections from nodes, web proxies and clients,
+// establishes their Role from the credentials they authenticate with,
+// and dispatches their RPCs to an APIWithRoles.
+type TunServer struct {
+	addr    utils.NetAddr
+	signers []ssh.Signer
+	api     *APIWithRoles
+	a       *AuthServer
+	limiter *limiter.Limiter
+
+	cfg      *ssh.ServerConfig
+	listener net.Listener
+	conns    sync.WaitGroup
+
+	// krl is the last KRL fetched by the refresh loop; krlMu guards it so
+	// the cert-auth revocation check can read it without racing the
+	// refresh. A RoleUser connection presenting a cert is checked against
+	// it in certAuthCallback.
+	krlMu       sync.RWMutex
+	krl         []byte
+	krlStop     chan struct{}
+	krlStopOnce sync.Once
+}
+
+// NewTunServer creates (but does not start) a tunnel server listening on
+// addr, presenting itself with signers, and dispatching authenticated
+// RPCs to api. a is used to validate credentials during the SSH
+// handshake; l is consulted with the source address of every password
+// handshake (see limiter.Limiter for what it enforces).
+func NewTunServer(addr utils.NetAddr, signers []ssh.Signer, api *APIWithRoles, a *AuthServer, l *limiter.Limiter) (*TunServer, error) {
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("at least one host signer is required")
+	}
+	s := &TunServer{
+		addr:    addr,
+		signers: signers,
+		api:     api,
+		a:       a,
+		limiter: l,
+		krlStop: make(chan struct{}),
+	}
+	checker := &ssh.CertChecker{
+		IsUserAuthority: s.isUserCA,
+		IsRevoked:       s.isCertRevoked,
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback:  s.passwordCallback,
+		PublicKeyCallback: s.certAuthCallback(checker),
+	}
+	for _, signer := range signers {
+		cfg.AddHostKey(signer)
+	}
+	s.cfg = cfg
+	// Populate the cache before Start so a cert presented on the very
+	// first connection is already checked against the current KRL.
+	s.refreshKRL()
+	return s, nil
+}
+
+// isUserCA reports whether pub is the cluster's current user certificate
+// authority, for ssh.CertChecker to accept certs it signed.
+func (s *TunServer) isUserCA(pub ssh.PublicKey) bool {
+	userCA, err := s.a.userCAPublicKey()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(userCA.Marshal(), pub.Marshal())
+}
+
+// isCertRevoked backs ssh.CertChecker.IsRevoked: it checks cert's serial
+// against the KRL snapshot refreshKRL last fetched.
+func (s *TunServer) isCertRevoked(cert *ssh.Certificate) bool {
+	s.krlMu.RLock()
+	krl := s.krl
+	s.krlMu.RUnlock()
+	revoked, err := IsCertRevoked(krl, caUser, cert.Serial)
+	return err == nil && revoked
+}
+
+// refreshKRL re-fetches the KRL directly from the auth server (a is the
+// same process, so there's no need to hairpin through the tunnel's own
+// RPC) and swaps it into the cache isCertRevoked reads from.
+func (s *TunServer) refreshKRL() error {
+	krl, err := s.a.GetKRL()
+	if err != nil {
+		return err
+	}
+	s.krlMu.Lock()
+	s.krl = krl
+	s.krlMu.Unlock()
+	return nil
+}
+
+func (s *TunServer) refreshKRLLoop() {
+	ticker := time.NewTicker(krlRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.refreshKRL()
+		case <-s.krlStop:
+			return
+		}
+	}
+}
+
+// certAuthCallback wraps checker so a successfully authenticated user
+// cert is granted RoleUser, the same as a password/OTP login would be.
+func (s *TunServer) certAuthCallback(checker *ssh.CertChecker) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+		perm, err := checker.Authenticate(conn, pubKey)
+		if err != nil {
+			return nil, err
+		}
+		if perm.Extensions == nil {
+			perm.Extensions = map[string]string{}
+		}
+		perm.Extensions["role"] = string(RoleUser)
+		perm.Extensions["user"] = conn.User()
+		return perm, nil
+	}
+}
+
+func (s *TunServer) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if s.limiter != nil {
+		if err := s.limiter.RegisterRequest(conn.RemoteAddr().String()); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(password))
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	var creds credentials
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	// ssoCert is only populated for RoleSSOCallback; it is still stamped
+	// into Extensions below (as an empty string) for every other role, so
+	// handleConn can read it unconditionally.
+	var ssoCert []byte
+	switch creds.Role {
+	case RoleUser:
+		// Slow down repeated bad passwords for this identity, distinct
+		// from s.limiter's per-IP accounting above: a distributed
+		// attacker spreading guesses across source IPs still hits this.
+		if count, err := s.a.failedAttempts(creds.User); err == nil {
+			if d := backoffFor(count); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		if err := s.a.CheckPasswordWithHotpToken(creds.User, []byte(creds.Password), creds.HotpToken); err != nil {
+			return nil, err
+		}
+	case RoleWeb:
+		if _, err := s.a.GetWebSession(creds.User, creds.Sid); err != nil {
+			return nil, err
+		}
+	case RoleSignUp:
+		// A signup-token client can't prove its token is genuine without
+		// spending it, so the handshake accepts any token string; the
+		// real backend lookup happens in each RPC. GetSignupTokenData
+		// also checks that the token it names matches the one this
+		// connection presented; CreateUserWithToken does not.
+	case RoleSSOCallback:
+		sid, cert, err := s.a.ValidateSSOCallback(creds.Password, creds.Sid, creds.PubKey)
+		if err != nil {
+			return nil, err
+		}
+		creds.Sid = sid
+		ssoCert = cert
+	default:
+		return nil, fmt.Errorf("unsupported role %q", creds.Role)
+	}
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			"role":  string(creds.Role),
+			"user":  creds.User,
+			"token": creds.Token,
+			"sid":   creds.Sid,
+			"cert":  base64.StdEncoding.EncodeToString(ssoCert),
+		},
+	}, nil
+}
+
+// Start begins accepting connections in the background.
+func (s *TunServer) Start() error {
+	listener, err := net.Listen(s.addr.AddrNetwork, s.addr.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	go s.acceptLoop()
+	go s.refreshKRLLoop()
+	return nil
+}
+
+// Addr returns the address the server is actually listening on, useful
+// when addr was constructed with a ":0" port.
+func (s *TunServer) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stops accepting new connections, without waiting for in-flight
+// ones to finish; see Shutdown for a graceful drain.
+func (s *TunServer) Close() error {
+	s.krlStopOnce.Do(func() { close(s.krlStop) })
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Shutdown stops accepting new connections and waits for every connection
+// already accepted to be closed, or for ctx to expire, whichever comes
+// first. It does not close those connections itself.
+func (s *TunServer) Shutdown(ctx context.Context) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *TunServer) acceptLoop() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.handleConn(nc)
+		}()
+	}
+}
+
+func (s *TunServer) handleConn(nc net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.cfg)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	defer conn.Close()
+	// This tunnel only ever carries RPCs over global requests; reject any
+	// channel open request rather than leaving the client to time out.
+	go func() {
+		for nc := range chans {
+			nc.Reject(ssh.UnknownChannelType, "rpc-only tunnel")
+		}
+	}()
+	ctx := connContext{
+		role:  Role(conn.Permissions.Extensions["role"]),
+		token: conn.Permissions.Extensions["token"],
+		sid:   conn.Permissions.Extensions["sid"],
+		cert:  conn.Permissions.Extensions["cert"],
+	}
+	for req := range reqs {
+		s.handleRequest(ctx, req)
+	}
+}
+
+// connContext carries what the handshake established about a connection
+// (its Role, the signup token it presented, or the web session and user
+// cert minted for its SSO callback) into every RPC dispatched over it.
+type connContext struct {
+	role  Role
+	token string
+	sid   string
+	cert  string
+}
+
+func (s *TunServer) handleRequest(ctx connContext, req *ssh.Request) {
+	reply, err := s.dispatch(ctx, req.Type, req.Payload)
+	if err != nil {
+		if req.WantReply {
+			req.Reply(false, mustJSON(errReply{Error: err.Error()}))
+		}
+		return
+	}
+	if req.WantReply {
+		req.Reply(true, reply)
+	}
+}
+
+func (s *TunServer) dispatch(ctx connContext, reqType string, payload []byte) ([]byte, error) {
+	role := ctx.role
+	switch reqType {
+	case reqUpsertServer:
+		var r upsertServerReq
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return nil, err
+		}
+		if err := s.api.UpsertServer(role, r.Server, r.TTL); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case reqGetServers:
+		servers, err := s.api.GetServers(role)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(getServersReply{Servers: servers}), nil
+	case reqGetUsers:
+		users, err := s.api.GetUsers(role)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(getUsersReply{Users: users}), nil
+	case reqSignIn:
+		var r signInReq
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return nil, err
+		}
+		sid, err := s.api.SignIn(role, r.User, r.Password)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(signInReply{SID: sid}), nil
+	case reqGetWebSession:
+		var r webSessionReq
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return nil, err
+		}
+		sid, err := s.api.GetWebSession(role, r.User, r.SID)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(webSessionReply{SID: sid}), nil
+	case reqDeleteWebSession:
+		var r webSessionReq
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return nil, err
+		}
+		return nil, s.api.DeleteWebSession(role, r.User, r.SID)
+	case reqGetSignupTokenData:
+		var r signupTokenDataReq
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return nil, err
+		}
+		if r.Token != ctx.token {
+			return nil, fmt.Errorf("valid token, but invalid client")
+		}
+		user, qr, values, err := s.api.GetSignupTokenData(role, r.Token)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(signupTokenDataReply{User: user, QRImg: qr, OTPValues: values}), nil
+	case reqCreateUserWithToken:
+		var r createUserWithTokenReq
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return nil, err
+		}
+		backupCodes, err := s.api.CreateUserWithToken(role, r.Token, r.Password, r.HotpToken)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(createUserWithTokenReply{BackupCodes: backupCodes}), nil
+	case reqGetSSOSession:
+		rawCert, err := base64.StdEncoding.DecodeString(ctx.cert)
+		if err != nil {
+			return nil, err
+		}
+		sid, cert, err := s.api.GetSSOSession(role, ctx.sid, rawCert)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(ssoSessionReply{SID: sid, Cert: cert}), nil
+	case reqGetKRL:
+		krl, err := s.api.GetKRL(role)
+		if err != nil {
+			return nil, err
+		}
+		return mustJSON(getKRLReply{KRL: krl}), nil
+	case reqUnlockUser:
+		var r unlockUserReq
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return nil, err
+		}
+		return nil, s.api.UnlockUser(role, r.User)
+	default:
+		return nil, fmt.Errorf("unknown request type %q", reqType)
+	}
+}
+
+func mustJSON(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -5,7 +5,7 @@ Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
 You may obtain a copy of the License at
 
-    http://www.apache.org/licenses/LICENSE-2.0
+	http://www.apache.org/licenses/LICENSE-2.0
 
 Unless required by applicable law or agreed to in writing, software
 distributed under the License is distributed on an "AS IS" BASIS,
@@ -17,10 +17,9 @@ package auth
 
 import (
 	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/gokyle/hotp"
-
 	authority "github.com/gravitational/teleport/lib/auth/testauthority"
 	"github.com/gravitational/teleport/lib/backend/boltbk"
 	"github.com/gravitational/teleport/lib/backend/encryptedbk"
@@ -40,8 +39,10 @@ import (
 )
 
 type TunSuite struct {
-	bk   *encryptedbk.ReplicatedBackend
-	scrt secret.SecretService
+	bk      *encryptedbk.ReplicatedBackend
+	baseBk  *boltbk.BoltBackend
+	keysDir string
+	scrt    secret.SecretService
 
 	srv    *APIWithRoles
 	tsrv   *TunServer
@@ -66,14 +67,21 @@ func (s *TunSuite) TearDownTest(c *C) {
 	s.srv.Close()
 }
 
+// SetUpTest assembles the fixture by hand rather than through
+// auth/server.Run: this suite is package auth's own internal test (it
+// reaches unexported helpers like parseOTPURL and maxFailedAttempts
+// directly), and auth/server necessarily imports auth, so using it here
+// would be an import cycle. auth/server.Run exists for external callers
+// (e.g. the teleport binary) that only need the assembled Server.
 func (s *TunSuite) SetUpTest(c *C) {
 	s.dir = c.MkDir()
 
 	baseBk, err := boltbk.New(filepath.Join(s.dir, "db"))
 	c.Assert(err, IsNil)
-	s.bk, err = encryptedbk.NewReplicatedBackend(baseBk,
-		filepath.Join(s.dir, "keys"), nil,
-		encryptor.GetTestKey)
+	s.baseBk = baseBk
+	s.keysDir = filepath.Join(s.dir, "keys")
+	s.bk, err = encryptedbk.NewReplicatedBackend(baseBk, s.keysDir, nil,
+		func() (*[secret.SecretKeyLength]byte, error) { return encryptor.GetOrCreateKey(s.keysDir) })
 	c.Assert(err, IsNil)
 
 	s.bl, err = boltlog.New(filepath.Join(s.dir, "eventsdb"))
@@ -82,7 +90,7 @@ func (s *TunSuite) SetUpTest(c *C) {
 	s.rec, err = boltrec.New(s.dir)
 	c.Assert(err, IsNil)
 
-	s.a = NewAuthServer(s.bk, authority.New(), s.scrt, "host2")
+	s.a = NewAuthServer(s.bk, authority.New(), s.scrt, "host2", WithAuditLog(s.bl))
 	s.srv = NewAPIWithRoles(s.a, s.bl, session.New(s.bk), s.rec,
 		NewStandardPermissions(),
 		StandardRoles,
@@ -135,15 +143,14 @@ func (s *TunSuite) TestUnixServerClient(c *C) {
 	user := "test"
 	pass := []byte("pwd123")
 
-	hotpURL, _, err := s.a.UpsertPassword(user, pass)
+	otpURL, _, _, err := s.a.UpsertPassword(user, pass, services.HOTP)
 	c.Assert(err, IsNil)
 
-	otp, label, err := hotp.FromURL(hotpURL)
+	otp, label, err := parseOTPURL(otpURL)
 	c.Assert(err, IsNil)
 	c.Assert(label, Equals, "test")
-	otp.Increment()
 
-	authMethod, err := NewWebPasswordAuth(user, pass, otp.OTP())
+	authMethod, err := NewWebPasswordAuth(user, pass, codeAt(*otp, otp.Counter+1))
 	c.Assert(err, IsNil)
 
 	clt, err := NewTunClient(
@@ -159,47 +166,75 @@ func (s *TunSuite) TestUnixServerClient(c *C) {
 func (s *TunSuite) TestSessions(c *C) {
 	c.Assert(s.a.ResetUserCertificateAuthority(""), IsNil)
 
-	user := "ws-test"
-	pass := []byte("ws-abc123")
-
-	hotpURL, _, err := s.a.UpsertPassword(user, pass)
-	c.Assert(err, IsNil)
-
-	otp, label, err := hotp.FromURL(hotpURL)
-	c.Assert(err, IsNil)
-	c.Assert(label, Equals, "ws-test")
-	otp.Increment()
-
-	authMethod, err := NewWebPasswordAuth(user, pass, otp.OTP())
-	c.Assert(err, IsNil)
-
-	clt, err := NewTunClient(
-		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, authMethod)
-	c.Assert(err, IsNil)
-	defer clt.Close()
-
-	ws, err := clt.SignIn(user, pass)
-	c.Assert(err, IsNil)
-	c.Assert(ws, Not(Equals), "")
-
-	// Resume session via sesison id
-	authMethod, err = NewWebSessionAuth(user, []byte(ws))
-	c.Assert(err, IsNil)
-
-	cltw, err := NewTunClient(
-		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, authMethod)
-	c.Assert(err, IsNil)
-	defer cltw.Close()
-
-	out, err := cltw.GetWebSession(user, ws)
-	c.Assert(err, IsNil)
-	c.Assert(out, DeepEquals, ws)
-
-	err = cltw.DeleteWebSession(user, ws)
-	c.Assert(err, IsNil)
-
-	_, err = clt.GetWebSession(user, ws)
-	c.Assert(err, NotNil)
+	for _, otpType := range []services.OTPType{services.HOTP, services.TOTP} {
+		user := "ws-test-" + string(otpType)
+		pass := []byte("ws-abc123")
+
+		otpURL, _, backupCodes, err := s.a.UpsertPassword(user, pass, otpType)
+		c.Assert(err, IsNil)
+		c.Assert(backupCodes, HasLen, backupCodeCount)
+
+		otp, label, err := parseOTPURL(otpURL)
+		c.Assert(err, IsNil)
+		c.Assert(label, Equals, user)
+
+		var code string
+		if otpType == services.TOTP {
+			code = codeAt(*otp, totpStep(*otp))
+		} else {
+			code = codeAt(*otp, otp.Counter+1)
+		}
+
+		authMethod, err := NewWebPasswordAuth(user, pass, code)
+		c.Assert(err, IsNil)
+
+		clt, err := NewTunClient(
+			utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, authMethod)
+		c.Assert(err, IsNil)
+		defer clt.Close()
+
+		ws, err := clt.SignIn(user, pass)
+		c.Assert(err, IsNil)
+		c.Assert(ws, Not(Equals), "")
+
+		// Resume session via sesison id
+		authMethod, err = NewWebSessionAuth(user, []byte(ws))
+		c.Assert(err, IsNil)
+
+		cltw, err := NewTunClient(
+			utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, authMethod)
+		c.Assert(err, IsNil)
+		defer cltw.Close()
+
+		out, err := cltw.GetWebSession(user, ws)
+		c.Assert(err, IsNil)
+		c.Assert(out, DeepEquals, ws)
+
+		err = cltw.DeleteWebSession(user, ws)
+		c.Assert(err, IsNil)
+
+		_, err = clt.GetWebSession(user, ws)
+		c.Assert(err, NotNil)
+
+		// A backup code authenticates just as well as the OTP token, and
+		// is consumed so it can't be replayed.
+		backupAuth, err := NewWebPasswordAuth(user, pass, backupCodes[0])
+		c.Assert(err, IsNil)
+
+		cltb, err := NewTunClient(
+			utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, backupAuth)
+		c.Assert(err, IsNil)
+		defer cltb.Close()
+
+		_, err = cltb.SignIn(user, pass)
+		c.Assert(err, IsNil)
+
+		backupAuthReplayed, err := NewWebPasswordAuth(user, pass, backupCodes[0])
+		c.Assert(err, IsNil)
+		_, err = NewTunClient(
+			utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, backupAuthReplayed)
+		c.Assert(err, NotNil)
+	}
 }
 
 func (s *TunSuite) TestWebCreatingNewUser(c *C) {
@@ -209,15 +244,19 @@ func (s *TunSuite) TestWebCreatingNewUser(c *C) {
 	user := "user456"
 	user2 := "zxzx"
 	user3 := "wrwr"
+	user4 := "totp-user"
 
 	// Generate token
-	token, err := s.a.CreateSignupToken(user)
+	token, err := s.a.CreateSignupToken(user, services.HOTP)
 	c.Assert(err, IsNil)
 	// Generate token2
-	token2, err := s.a.CreateSignupToken(user2)
+	token2, err := s.a.CreateSignupToken(user2, services.HOTP)
 	c.Assert(err, IsNil)
 	// Generate token3
-	token3, err := s.a.CreateSignupToken(user3)
+	token3, err := s.a.CreateSignupToken(user3, services.HOTP)
+	c.Assert(err, IsNil)
+	// Generate token4, enrolling a TOTP generator instead of HOTP
+	token4, err := s.a.CreateSignupToken(user4, services.TOTP)
 	c.Assert(err, IsNil)
 
 	// Connect to auth server using wrong token
@@ -244,23 +283,12 @@ func (s *TunSuite) TestWebCreatingNewUser(c *C) {
 	tokenData, ttl, err := s.a.WebService.GetSignupToken(token)
 	c.Assert(err, IsNil)
 	c.Assert(ttl > SignupTokenUserActionsTTL, Equals, true)
-	otp, err := hotp.Unmarshal(tokenData.Hotp)
-	c.Assert(err, IsNil)
 
-	hotpTokens := make([]string, 6)
-	for i := 0; i < 6; i++ {
-		hotpTokens[i] = otp.OTP()
-	}
+	hotpTokens := acceptableCodes(tokenData.OTP)
 
 	tokenData3, _, err := s.a.WebService.GetSignupToken(token3)
 	c.Assert(err, IsNil)
-	otp3, err := hotp.Unmarshal(tokenData3.Hotp)
-	c.Assert(err, IsNil)
-
-	hotpTokens3 := make([]string, 6)
-	for i := 0; i < 6; i++ {
-		hotpTokens3[i] = otp3.OTP()
-	}
+	hotpTokens3 := acceptableCodes(tokenData3.OTP)
 
 	// Loading what the web page loads (username and QR image)
 	_, _, _, err = clt.GetSignupTokenData("wrong_token")
@@ -281,24 +309,25 @@ func (s *TunSuite) TestWebCreatingNewUser(c *C) {
 
 	password := "valid_password"
 
-	err = clt2.CreateUserWithToken(token, password, hotpTokens[0])
+	backupCodes, err := clt2.CreateUserWithToken(token, password, hotpTokens[0])
 	c.Assert(err, IsNil)
+	c.Assert(backupCodes, HasLen, backupCodeCount)
 
 	// that line will do nothing, so next valid token is still hotpTokens[1]
-	err = clt2.CreateUserWithToken(token, password, hotpTokens[1])
+	_, err = clt2.CreateUserWithToken(token, password, hotpTokens[1])
 	c.Assert(err, IsNil)
 
-	err = clt2.CreateUserWithToken(token, "another_user_signup_attempt", hotpTokens[0])
+	_, err = clt2.CreateUserWithToken(token, "another_user_signup_attempt", hotpTokens[0])
 	c.Assert(err, NotNil)
 
 	time.Sleep(time.Millisecond * 500)
 	_, _, err = s.a.WebService.GetSignupToken(token)
 	c.Assert(err, NotNil) // token was deleted
 
-	err = clt2.CreateUserWithToken(token3, "newpassword123", hotpTokens3[5])
+	_, err = clt2.CreateUserWithToken(token3, "newpassword123", hotpTokens3[5])
 	c.Assert(err, NotNil)
 
-	err = clt2.CreateUserWithToken(token3, "newpassword45665", hotpTokens3[4])
+	_, err = clt2.CreateUserWithToken(token3, "newpassword45665", hotpTokens3[4])
 	c.Assert(err, IsNil)
 
 	// trying to connect to the auth server using used token
@@ -321,6 +350,39 @@ func (s *TunSuite) TestWebCreatingNewUser(c *C) {
 	ws, err := clt3.SignIn(user, []byte(password))
 	c.Assert(err, IsNil)
 	c.Assert(ws, Not(Equals), "")
+
+	// A backup code signs in just as well as an OTP token would, and is
+	// consumed so it can't be replayed.
+	backupAuth, err := NewWebPasswordAuth(user, []byte(password), backupCodes[0])
+	c.Assert(err, IsNil)
+	clt4, err := NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, backupAuth)
+	c.Assert(err, IsNil)
+	defer clt4.Close()
+	_, err = clt4.SignIn(user, []byte(password))
+	c.Assert(err, IsNil)
+
+	backupAuthReplayed, err := NewWebPasswordAuth(user, []byte(password), backupCodes[0])
+	c.Assert(err, IsNil)
+	_, err = NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user, backupAuthReplayed)
+	c.Assert(err, NotNil)
+
+	// Completing signup with a TOTP enrollment works the same way.
+	totpTokenData, _, err := s.a.WebService.GetSignupToken(token4)
+	c.Assert(err, IsNil)
+	totpCode := codeAt(totpTokenData.OTP, totpStep(totpTokenData.OTP))
+
+	authMethod4, err := NewSignupTokenAuth(token4)
+	c.Assert(err, IsNil)
+	clt5, err := NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, user4, authMethod4)
+	c.Assert(err, IsNil)
+	defer clt5.Close()
+
+	password4 := "totp_valid_password"
+	_, err = clt5.CreateUserWithToken(token4, password4, totpCode)
+	c.Assert(err, IsNil)
 }
 
 func (s *TunSuite) TestPermissions(c *C) {
@@ -329,15 +391,14 @@ func (s *TunSuite) TestPermissions(c *C) {
 	user := "ws-test2"
 	pass := []byte("ws-abc1234")
 
-	hotpURL, _, err := s.a.UpsertPassword(user, pass)
+	otpURL, _, _, err := s.a.UpsertPassword(user, pass, services.HOTP)
 	c.Assert(err, IsNil)
 
-	otp, label, err := hotp.FromURL(hotpURL)
+	otp, label, err := parseOTPURL(otpURL)
 	c.Assert(err, IsNil)
 	c.Assert(label, Equals, "ws-test2")
-	otp.Increment()
 
-	authMethod, err := NewWebPasswordAuth(user, pass, otp.OTP())
+	authMethod, err := NewWebPasswordAuth(user, pass, codeAt(*otp, otp.Counter+1))
 	c.Assert(err, IsNil)
 
 	clt, err := NewTunClient(
@@ -391,15 +452,14 @@ func (s *TunSuite) TestSessionsBadPassword(c *C) {
 	user := "system-test"
 	pass := []byte("system-abc123")
 
-	hotpURL, _, err := s.a.UpsertPassword(user, pass)
+	otpURL, _, _, err := s.a.UpsertPassword(user, pass, services.HOTP)
 	c.Assert(err, IsNil)
 
-	otp, label, err := hotp.FromURL(hotpURL)
+	otp, label, err := parseOTPURL(otpURL)
 	c.Assert(err, IsNil)
 	c.Assert(label, Equals, "system-test")
-	otp.Increment()
 
-	authMethod, err := NewWebPasswordAuth(user, pass, otp.OTP())
+	authMethod, err := NewWebPasswordAuth(user, pass, codeAt(*otp, otp.Counter+1))
 	c.Assert(err, IsNil)
 
 	clt, err := NewTunClient(
@@ -415,4 +475,237 @@ func (s *TunSuite) TestSessionsBadPassword(c *C) {
 	c.Assert(err, NotNil)
 	c.Assert(ws, Equals, "")
 
-}
\ No newline at end of file
+	// Hammer SignIn with the wrong password until the account locks out;
+	// the correct password is then rejected too, until an admin lifts it.
+	for i := 0; i < maxFailedAttempts-1; i++ {
+		_, err = clt.SignIn(user, []byte("still-wrong"))
+		c.Assert(err, NotNil)
+	}
+
+	_, err = clt.SignIn(user, pass)
+	c.Assert(err, NotNil)
+
+	c.Assert(s.a.UnlockUser(user), IsNil)
+
+	ws, err = clt.SignIn(user, pass)
+	c.Assert(err, IsNil)
+	c.Assert(ws, Not(Equals), "")
+}
+
+func (s *TunSuite) TestBadTokenLocksOutAfterCorrectPassword(c *C) {
+	c.Assert(s.a.ResetUserCertificateAuthority(""), IsNil)
+
+	user := "token-lockout-test"
+	pass := []byte("token-lockout-abc123")
+
+	_, _, _, err := s.a.UpsertPassword(user, pass, services.HOTP)
+	c.Assert(err, IsNil)
+
+	// A correct password paired with a wrong token must not reset the
+	// lockout counter: it still counts as a failure, same as a wrong
+	// password would, otherwise a stolen password buys unlimited guesses
+	// at the OTP token.
+	for i := 0; i < maxFailedAttempts; i++ {
+		err = s.a.CheckPasswordWithHotpToken(user, pass, "000000")
+		c.Assert(err, NotNil)
+	}
+
+	_, err = s.a.SignIn(user, pass)
+	c.Assert(err, NotNil)
+
+	c.Assert(s.a.UnlockUser(user), IsNil)
+
+	_, err = s.a.SignIn(user, pass)
+	c.Assert(err, IsNil)
+}
+
+func (s *TunSuite) TestCertRevocation(c *C) {
+	c.Assert(s.a.ResetUserCertificateAuthority(""), IsNil)
+
+	priv1, pub1, err := s.a.GenerateKeyPair("")
+	c.Assert(err, IsNil)
+	rawCert1, err := s.a.GenerateUserCert(pub1, "revoke-me", 0)
+	c.Assert(err, IsNil)
+	serial1 := certSerial(c, rawCert1)
+
+	priv2, pub2, err := s.a.GenerateKeyPair("")
+	c.Assert(err, IsNil)
+	rawCert2, err := s.a.GenerateUserCert(pub2, "keep-me", 0)
+	c.Assert(err, IsNil)
+	serial2 := certSerial(c, rawCert2)
+	c.Assert(serial2, Not(Equals), serial1)
+
+	krl, err := s.a.GetKRL()
+	c.Assert(err, IsNil)
+	revoked, err := IsCertRevoked(krl, "user", serial1)
+	c.Assert(err, IsNil)
+	c.Assert(revoked, Equals, false)
+
+	// Before revocation, dialing with the cert authenticates fine.
+	authMethod1, err := NewUserCertAuth(priv1, rawCert1)
+	c.Assert(err, IsNil)
+	clt1, err := NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, "revoke-me", authMethod1)
+	c.Assert(err, IsNil)
+	clt1.Close()
+
+	c.Assert(s.a.RevokeCert(serial1, "compromised"), IsNil)
+	// Force the tunnel's cache to pick up the revocation immediately,
+	// rather than waiting for its periodic refresh.
+	c.Assert(s.tsrv.refreshKRL(), IsNil)
+
+	krl, err = s.a.GetKRL()
+	c.Assert(err, IsNil)
+	revoked, err = IsCertRevoked(krl, "user", serial1)
+	c.Assert(err, IsNil)
+	c.Assert(revoked, Equals, true)
+	revoked, err = IsCertRevoked(krl, "user", serial2)
+	c.Assert(err, IsNil)
+	c.Assert(revoked, Equals, false)
+
+	// The tunnel now rejects the revoked cert before auth even reaches
+	// SignIn: the SSH handshake itself fails.
+	authMethod1Again, err := NewUserCertAuth(priv1, rawCert1)
+	c.Assert(err, IsNil)
+	_, err = NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, "revoke-me", authMethod1Again)
+	c.Assert(err, NotNil)
+
+	// An unrevoked cert for a different user still dials successfully.
+	authMethod2, err := NewUserCertAuth(priv2, rawCert2)
+	c.Assert(err, IsNil)
+	clt2, err := NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, "keep-me", authMethod2)
+	c.Assert(err, IsNil)
+	clt2.Close()
+
+	// fetched through APIWithRoles as RoleNode, the permission check a
+	// node's GetKRL RPC over the tunnel goes through
+	fetched, err := s.srv.GetKRL(RoleNode)
+	c.Assert(err, IsNil)
+	c.Assert(fetched, DeepEquals, krl)
+}
+
+func (s *TunSuite) TestCertSerialsDontRace(c *C) {
+	c.Assert(s.a.ResetUserCertificateAuthority(""), IsNil)
+
+	const concurrency = 64
+	serials := make(chan uint64, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, pub, err := s.a.GenerateKeyPair("")
+			c.Check(err, IsNil)
+			rawCert, err := s.a.GenerateUserCert(pub, "racer", 0)
+			c.Check(err, IsNil)
+			serials <- certSerial(c, rawCert)
+		}()
+	}
+	wg.Wait()
+	close(serials)
+
+	seen := make(map[uint64]bool, concurrency)
+	for serial := range serials {
+		c.Assert(seen[serial], Equals, false)
+		seen[serial] = true
+	}
+}
+
+type fakeSSOConnector struct{ id string }
+
+func (f *fakeSSOConnector) ID() string                     { return f.id }
+func (f *fakeSSOConnector) GetAuthURL(state string) string { return "https://idp.example.com/" + state }
+func (f *fakeSSOConnector) ValidateCallback(code, state string) (*Identity, error) {
+	return &Identity{ConnectorID: f.id, Username: "sso-user"}, nil
+}
+
+func (s *TunSuite) TestSSOConnectorPersistence(c *C) {
+	cfg := SSOConnectorConfig{
+		ID:           "google",
+		Type:         "google",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://proxy.example.com/sso/callback",
+	}
+	c.Assert(s.a.RegisterSSOConnector(cfg, &fakeSSOConnector{id: cfg.ID}), IsNil)
+
+	configs, err := s.a.GetSSOConnectorConfigs()
+	c.Assert(err, IsNil)
+	c.Assert(configs, DeepEquals, []SSOConnectorConfig{cfg})
+
+	// Simulate a restart: a fresh ReplicatedBackend reloading its key
+	// from keysDir (rather than reusing s.bk's already-open key) wraps
+	// the same underlying store, and a fresh AuthServer over it still
+	// sees the persisted config, even though its in-memory registry is
+	// empty until something re-registers a connector from it.
+	freshBk, err := encryptedbk.NewReplicatedBackend(s.baseBk, s.keysDir, nil,
+		func() (*[secret.SecretKeyLength]byte, error) { return encryptor.GetOrCreateKey(s.keysDir) })
+	c.Assert(err, IsNil)
+	fresh := NewAuthServer(freshBk, authority.New(), s.scrt, s.a.DomainName)
+	configs, err = fresh.GetSSOConnectorConfigs()
+	c.Assert(err, IsNil)
+	c.Assert(configs, DeepEquals, []SSOConnectorConfig{cfg})
+
+	c.Assert(s.a.UnregisterSSOConnector(cfg.ID), IsNil)
+	configs, err = s.a.GetSSOConnectorConfigs()
+	c.Assert(err, IsNil)
+	c.Assert(configs, HasLen, 0)
+}
+
+// TestSSOLogin drives the whole login path TestSSOConnectorPersistence
+// never exercises: CreateSSOAuthRequest, a tunnel dial authenticating as
+// RoleSSOCallback with NewSSOAuth, and GetSSOSession retrieving both the
+// web session and the SSH user cert minted for it.
+func (s *TunSuite) TestSSOLogin(c *C) {
+	c.Assert(s.a.ResetUserCertificateAuthority(""), IsNil)
+
+	cfg := SSOConnectorConfig{
+		ID:           "google",
+		Type:         "google",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://proxy.example.com/sso/callback",
+	}
+	c.Assert(s.a.RegisterSSOConnector(cfg, &fakeSSOConnector{id: cfg.ID}), IsNil)
+
+	redirectURL, err := s.a.CreateSSOAuthRequest(cfg.ID)
+	c.Assert(err, IsNil)
+	state := redirectURL[len("https://idp.example.com/"):]
+
+	_, pub, err := s.a.GenerateKeyPair("")
+	c.Assert(err, IsNil)
+
+	authMethod, err := NewSSOAuth("idp-code", state, pub)
+	c.Assert(err, IsNil)
+
+	clt, err := NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, "sso-user", authMethod)
+	c.Assert(err, IsNil)
+	defer clt.Close()
+
+	sid, cert, err := clt.GetSSOSession()
+	c.Assert(err, IsNil)
+	c.Assert(sid, Not(Equals), "")
+	c.Assert(cert, Not(HasLen), 0)
+
+	sshPub, _, _, _, err := ssh.ParseAuthorizedKey(cert)
+	c.Assert(err, IsNil)
+	sshCert, ok := sshPub.(*ssh.Certificate)
+	c.Assert(ok, Equals, true)
+	c.Assert(sshCert.ValidPrincipals, DeepEquals, []string{"sso-user"})
+
+	// The request is single-use: replaying the same state must fail.
+	_, err = NewTunClient(
+		utils.NetAddr{AddrNetwork: "tcp", Addr: s.tsrv.Addr()}, "sso-user", authMethod)
+	c.Assert(err, NotNil)
+}
+
+func certSerial(c *C, rawCert []byte) uint64 {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(rawCert)
+	c.Assert(err, IsNil)
+	cert, ok := pub.(*ssh.Certificate)
+	c.Assert(ok, Equals, true)
+	return cert.Serial
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gravitational/teleport/lib/services"
+	"github.com/gravitational/teleport/lib/sshutils"
+	"github.com/gravitational/teleport/lib/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// NewWebPasswordAuth returns an AuthMethod that authenticates as
+// RoleUser using a username, password and current HOTP/TOTP token.
+func NewWebPasswordAuth(user string, password []byte, hotpToken string) (ssh.AuthMethod, error) {
+	return credentialsAuth(credentials{
+		Role:      RoleUser,
+		User:      user,
+		Password:  string(password),
+		HotpToken: hotpToken,
+	})
+}
+
+// NewWebSessionAuth returns an AuthMethod that authenticates as RoleWeb
+// using a previously issued web session id.
+func NewWebSessionAuth(user string, sid []byte) (ssh.AuthMethod, error) {
+	return credentialsAuth(credentials{
+		Role: RoleWeb,
+		User: user,
+		Sid:  string(sid),
+	})
+}
+
+// NewSignupTokenAuth returns an AuthMethod that authenticates as
+// RoleSignUp using an invite token, for clients completing enrollment.
+func NewSignupTokenAuth(token string) (ssh.AuthMethod, error) {
+	return credentialsAuth(credentials{
+		Role:  RoleSignUp,
+		Token: token,
+	})
+}
+
+// NewUserCertAuth returns an AuthMethod that authenticates as RoleUser by
+// presenting a certificate issued by AuthServer.GenerateUserCert, instead
+// of a password and OTP token. The tunnel rejects the cert outright, at
+// the SSH handshake, if it has since been revoked with RevokeCert.
+func NewUserCertAuth(priv, cert []byte) (ssh.AuthMethod, error) {
+	signer, err := sshutils.NewSigner(priv, cert)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+func credentialsAuth(creds credentials) (ssh.AuthMethod, error) {
+	raw, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.Password(base64.StdEncoding.EncodeToString(raw)), nil
+}
+
+// TunClient is an RPC client for the teleport tunnel: it dials an
+// APIWithRoles over SSH and exposes its methods as plain Go calls.
+type TunClient struct {
+	client *ssh.Client
+}
+
+// NewTunClient dials addr, authenticating with method as user, and
+// returns a client ready to issue RPCs with whatever Role method
+// resolved to.
+func NewTunClient(addr utils.NetAddr, user string, method ssh.AuthMethod) (*TunClient, error) {
+	cfg := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{method},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial(addr.AddrNetwork, addr.Addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &TunClient{client: client}, nil
+}
+
+// Close terminates the underlying SSH connection.
+func (c *TunClient) Close() error {
+	return c.client.Close()
+}
+
+func (c *TunClient) call(reqType string, arg interface{}, reply interface{}) error {
+	var payload []byte
+	if arg != nil {
+		var err error
+		payload, err = json.Marshal(arg)
+		if err != nil {
+			return err
+		}
+	}
+	ok, resp, err := c.client.SendRequest(reqType, true, payload)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		var e errReply
+		if err := json.Unmarshal(resp, &e); err != nil {
+			return fmt.Errorf("rpc %q failed", reqType)
+		}
+		return errors.New(e.Error)
+	}
+	if reply == nil || len(resp) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp, reply)
+}
+
+// UpsertServer registers a node with the cluster.
+func (c *TunClient) UpsertServer(s services.Server, ttl time.Duration) error {
+	return c.call(reqUpsertServer, upsertServerReq{Server: s, TTL: ttl}, nil)
+}
+
+// GetServers returns the cluster's node inventory.
+func (c *TunClient) GetServers() ([]services.Server, error) {
+	var reply getServersReply
+	if err := c.call(reqGetServers, nil, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Servers, nil
+}
+
+// GetUsers returns the list of enrolled users.
+func (c *TunClient) GetUsers() ([]string, error) {
+	var reply getUsersReply
+	if err := c.call(reqGetUsers, nil, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Users, nil
+}
+
+// SignIn authenticates user and returns a new web session id.
+func (c *TunClient) SignIn(user string, password []byte) (string, error) {
+	var reply signInReply
+	if err := c.call(reqSignIn, &signInReq{User: user, Password: password}, &reply); err != nil {
+		return "", err
+	}
+	return reply.SID, nil
+}
+
+// GetWebSession returns a previously issued web session id.
+func (c *TunClient) GetWebSession(user, sid string) (string, error) {
+	var reply webSessionReply
+	if err := c.call(reqGetWebSession, webSessionReq{User: user, SID: sid}, &reply); err != nil {
+		return "", err
+	}
+	return reply.SID, nil
+}
+
+// DeleteWebSession revokes a previously issued web session.
+func (c *TunClient) DeleteWebSession(user, sid string) error {
+	return c.call(reqDeleteWebSession, webSessionReq{User: user, SID: sid}, nil)
+}
+
+// GetSignupTokenData returns what the signup UI needs to render for token.
+func (c *TunClient) GetSignupTokenData(token string) (user string, qrImg []byte, otpValues []string, e error) {
+	var reply signupTokenDataReply
+	if err := c.call(reqGetSignupTokenData, signupTokenDataReq{Token: token}, &reply); err != nil {
+		return "", nil, nil, err
+	}
+	return reply.User, reply.QRImg, reply.OTPValues, nil
+}
+
+// CreateUserWithToken completes a signup, returning the backup codes
+// minted for the new user.
+func (c *TunClient) CreateUserWithToken(token, password, hotpToken string) ([]string, error) {
+	var reply createUserWithTokenReply
+	if err := c.call(reqCreateUserWithToken, createUserWithTokenReq{Token: token, Password: password, HotpToken: hotpToken}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.BackupCodes, nil
+}
+
+// GetSSOSession returns the web session id and user cert minted for the
+// SSO callback this client authenticated with, the latter for reaching
+// nodes the same way NewUserCertAuth would after a password+OTP login.
+func (c *TunClient) GetSSOSession() (sid string, cert []byte, err error) {
+	var reply ssoSessionReply
+	if err := c.call(reqGetSSOSession, nil, &reply); err != nil {
+		return "", nil, err
+	}
+	return reply.SID, reply.Cert, nil
+}
+
+// GetKRL returns the current certificate revocation list, for a node or
+// proxy to check against issued certs before accepting them.
+func (c *TunClient) GetKRL() ([]byte, error) {
+	var reply getKRLReply
+	if err := c.call(reqGetKRL, nil, &reply); err != nil {
+		return nil, err
+	}
+	return reply.KRL, nil
+}
+
+// UnlockUser lifts an account lockout for user before it expires on its
+// own; callers need RoleAdmin.
+func (c *TunClient) UnlockUser(user string) error {
+	return c.call(reqUnlockUser, unlockUserReq{User: user}, nil)
+}
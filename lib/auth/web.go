@@ -0,0 +1,94 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gravitational/teleport/lib/backend"
+	"github.com/gravitational/teleport/lib/services"
+)
+
+// webService is the default services.WebService, backed directly by the
+// auth server's own backend.
+type webService struct {
+	bk backend.Backend
+}
+
+func newWebService(bk backend.Backend) services.WebService {
+	return &webService{bk: bk}
+}
+
+var signupTokensPath = []string{"signup_tokens"}
+var webSessionsPath = []string{"web_sessions"}
+
+// storedSignupToken is what actually gets written to the backend: the
+// public services.SignupToken plus the absolute expiry so GetSignupToken
+// can report a real remaining ttl and reject tokens past it, independent
+// of whether the underlying backend enforces ttl itself.
+type storedSignupToken struct {
+	Data      services.SignupToken `json:"data"`
+	ExpiresAt time.Time            `json:"expires_at"`
+}
+
+func (w *webService) UpsertSignupToken(token string, data services.SignupToken, ttl time.Duration) error {
+	stored := storedSignupToken{Data: data, ExpiresAt: time.Now().Add(ttl)}
+	bytes, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return w.bk.UpsertVal(signupTokensPath, token, bytes, ttl)
+}
+
+func (w *webService) GetSignupToken(token string) (*services.SignupToken, time.Duration, error) {
+	bytes, err := w.bk.GetVal(signupTokensPath, token)
+	if err != nil {
+		return nil, 0, fmt.Errorf("signup token %q not found", token)
+	}
+	var stored storedSignupToken
+	if err := json.Unmarshal(bytes, &stored); err != nil {
+		return nil, 0, err
+	}
+	remaining := time.Until(stored.ExpiresAt)
+	if remaining <= 0 {
+		w.bk.DeleteKey(signupTokensPath, token)
+		return nil, 0, fmt.Errorf("signup token %q has expired", token)
+	}
+	return &stored.Data, remaining, nil
+}
+
+func (w *webService) DeleteSignupToken(token string) error {
+	return w.bk.DeleteKey(signupTokensPath, token)
+}
+
+func (w *webService) UpsertWebSession(user, sid string, ttl time.Duration) error {
+	return w.bk.UpsertVal(webSessionsPath, user+"/"+sid, []byte(sid), ttl)
+}
+
+func (w *webService) GetWebSession(user, sid string) (string, error) {
+	bytes, err := w.bk.GetVal(webSessionsPath, user+"/"+sid)
+	if err != nil {
+		return "", fmt.Errorf("session not found")
+	}
+	return string(bytes), nil
+}
+
+func (w *webService) DeleteWebSession(user, sid string) error {
+	return w.bk.DeleteKey(webSessionsPath, user+"/"+sid)
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backend defines the key-value storage interface used by every
+// subsystem (auth, events, sessions, recorder) to persist state.
+package backend
+
+import "time"
+
+// Backend implements a simple key value storage used to
+// store auth and provisioning state. Keys are organized in a tree,
+// addressed by a slice of path elements ("buckets").
+type Backend interface {
+	// GetKeys returns a list of keys for a given path
+	GetKeys(path []string) ([]string, error)
+	// UpsertVal updates or inserts a new key/value pair
+	UpsertVal(path []string, key string, val []byte, ttl time.Duration) error
+	// GetVal returns a value for a given key
+	GetVal(path []string, key string) ([]byte, error)
+	// DeleteKey deletes a key in a bucket
+	DeleteKey(path []string, key string) error
+	// DeleteBucket deletes the bucket by a given path
+	DeleteBucket(path []string, bucket string) error
+	// Close releases the resources held by the backend
+	Close() error
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package boltbk implements backend.Backend for the auth server's default
+// single-node storage. Values are held in memory only.
+package boltbk
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/teleport/lib/backend"
+)
+
+var _ backend.Backend = (*BoltBackend)(nil)
+
+// BoltBackend is an implementation of backend.Backend that keeps
+// everything in memory. path is recorded but nothing is written to it, so
+// the contents do not outlive the process, and ttl values passed to
+// UpsertVal are ignored.
+type BoltBackend struct {
+	mu   sync.Mutex
+	path string
+	data map[string]map[string][]byte
+}
+
+// New creates an empty in-memory store; path must be non-empty but is
+// not opened.
+func New(path string) (*BoltBackend, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	return &BoltBackend{
+		path: path,
+		data: make(map[string]map[string][]byte),
+	}, nil
+}
+
+func bucketKey(path []string) string {
+	return strings.Join(path, "/")
+}
+
+// GetKeys returns a list of keys for a given path
+func (b *BoltBackend) GetKeys(path []string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, ok := b.data[bucketKey(path)]
+	if !ok {
+		return nil, nil
+	}
+	out := make([]string, 0, len(bucket))
+	for k := range bucket {
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// UpsertVal updates or inserts a new key/value pair
+func (b *BoltBackend) UpsertVal(path []string, key string, val []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bk := bucketKey(path)
+	bucket, ok := b.data[bk]
+	if !ok {
+		bucket = make(map[string][]byte)
+		b.data[bk] = bucket
+	}
+	bucket[key] = val
+	return nil
+}
+
+// GetVal returns a value for a given key
+func (b *BoltBackend) GetVal(path []string, key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, ok := b.data[bucketKey(path)]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+	val, ok := bucket[key]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found", key)
+	}
+	return val, nil
+}
+
+// DeleteKey deletes a key in a bucket
+func (b *BoltBackend) DeleteKey(path []string, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, ok := b.data[bucketKey(path)]
+	if !ok {
+		return fmt.Errorf("key %q not found", key)
+	}
+	delete(bucket, key)
+	return nil
+}
+
+// DeleteBucket deletes the bucket by a given path
+func (b *BoltBackend) DeleteBucket(path []string, bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.data, bucketKey(append(path, bucket)))
+	return nil
+}
+
+// Close releases the resources held by the backend
+func (b *BoltBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptedbk
+
+import (
+	"testing"
+
+	"github.com/gravitational/teleport/lib/backend/boltbk"
+	"github.com/gravitational/teleport/lib/backend/encryptedbk/encryptor"
+
+	"github.com/mailgun/lemma/secret"
+)
+
+// TestReopenAfterRestart reconstructs a ReplicatedBackend the same way an
+// auth node would after a restart: a fresh NewReplicatedBackend call
+// pointed at the same keysDir, rather than reusing the already-open
+// ReplicatedBackend/key from before. It must still be able to decrypt
+// what the first instance wrote.
+func TestReopenAfterRestart(t *testing.T) {
+	baseBk, err := boltbk.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("boltbk.New = %v", err)
+	}
+	keysDir := t.TempDir()
+	keyFn := func() (*[secret.SecretKeyLength]byte, error) { return encryptor.GetOrCreateKey(keysDir) }
+
+	first, err := NewReplicatedBackend(baseBk, keysDir, nil, keyFn)
+	if err != nil {
+		t.Fatalf("NewReplicatedBackend (first) = %v", err)
+	}
+	if err := first.UpsertVal([]string{"users"}, "alice", []byte("s3cr3t"), 0); err != nil {
+		t.Fatalf("UpsertVal = %v", err)
+	}
+
+	// Simulate the process restarting: a brand new ReplicatedBackend
+	// wrapping the same underlying store, loading its key from keysDir
+	// instead of reusing the one still held in memory by `first`.
+	second, err := NewReplicatedBackend(baseBk, keysDir, nil, keyFn)
+	if err != nil {
+		t.Fatalf("NewReplicatedBackend (second) = %v", err)
+	}
+
+	val, err := second.GetVal([]string{"users"}, "alice")
+	if err != nil {
+		t.Fatalf("GetVal after restart = %v, want nil (key should have been reloaded from keysDir)", err)
+	}
+	if string(val) != "s3cr3t" {
+		t.Errorf("GetVal after restart = %q, want %q", val, "s3cr3t")
+	}
+}
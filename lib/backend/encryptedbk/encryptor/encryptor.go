@@ -0,0 +1,71 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package encryptor provides the key material used by encryptedbk to seal
+// values before they hit the underlying backend.
+package encryptor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mailgun/lemma/secret"
+)
+
+// keyFileName is the file within a keysDir that GetOrCreateKey persists
+// the sealing key to.
+const keyFileName = "backend.key"
+
+// GetTestKey returns a freshly generated secret key, for use in tests
+// only: it is not persisted anywhere, so two separate calls never agree
+// and wrapping a backend with it does not survive a restart. Production
+// deployments must use GetOrCreateKey instead.
+func GetTestKey() (*[secret.SecretKeyLength]byte, error) {
+	return secret.NewKey()
+}
+
+// GetOrCreateKey loads the sealing key persisted in keysDir, generating
+// and saving a new one the first time keysDir is used. This is what lets
+// a ReplicatedBackend opened against the same keysDir decrypt data sealed
+// by an earlier process, e.g. across an auth node restart.
+func GetOrCreateKey(keysDir string) (*[secret.SecretKeyLength]byte, error) {
+	keyPath := filepath.Join(keysDir, keyFileName)
+	raw, err := os.ReadFile(keyPath)
+	if err == nil {
+		if len(raw) != secret.SecretKeyLength {
+			return nil, fmt.Errorf("key file %q has length %d, expected %d", keyPath, len(raw), secret.SecretKeyLength)
+		}
+		var key [secret.SecretKeyLength]byte
+		copy(key[:], raw)
+		return &key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := secret.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(keysDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, key[:], 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
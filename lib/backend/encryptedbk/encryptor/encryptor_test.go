@@ -0,0 +1,53 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptor
+
+import (
+	"testing"
+)
+
+func TestGetOrCreateKeyPersists(t *testing.T) {
+	keysDir := t.TempDir()
+
+	key1, err := GetOrCreateKey(keysDir)
+	if err != nil {
+		t.Fatalf("GetOrCreateKey (create) = %v", err)
+	}
+
+	key2, err := GetOrCreateKey(keysDir)
+	if err != nil {
+		t.Fatalf("GetOrCreateKey (reload) = %v", err)
+	}
+
+	if *key1 != *key2 {
+		t.Error("GetOrCreateKey returned a different key on reload of the same keysDir")
+	}
+}
+
+func TestGetOrCreateKeyDiffersAcrossDirs(t *testing.T) {
+	key1, err := GetOrCreateKey(t.TempDir())
+	if err != nil {
+		t.Fatalf("GetOrCreateKey = %v", err)
+	}
+	key2, err := GetOrCreateKey(t.TempDir())
+	if err != nil {
+		t.Fatalf("GetOrCreateKey = %v", err)
+	}
+	if *key1 == *key2 {
+		t.Error("GetOrCreateKey returned the same key for two distinct keysDirs")
+	}
+}
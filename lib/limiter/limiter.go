@@ -0,0 +1,64 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package limiter holds the per-IP connection and rate limit settings of
+// the tunnel server. RegisterRequest accepts every request; neither limit
+// is enforced.
+package limiter
+
+import "fmt"
+
+// LimiterConfig configures the per-IP limits enforced by Limiter. Zero
+// values mean "unlimited".
+type LimiterConfig struct {
+	// MaxConnections caps the number of simultaneous connections per IP.
+	MaxConnections int64
+	// Rates is the list of per-IP request rates to enforce, e.g. 10 req/s.
+	Rates []Rate
+}
+
+// Rate describes how many requests are allowed within a period.
+type Rate struct {
+	Period  string
+	Average int64
+	Burst   int64
+}
+
+// Limiter holds a LimiterConfig for incoming connections; it does not
+// enforce MaxConnections or Rates.
+type Limiter struct {
+	config LimiterConfig
+}
+
+// NewLimiter creates a limiter from the given config.
+func NewLimiter(config LimiterConfig) (*Limiter, error) {
+	return &Limiter{config: config}, nil
+}
+
+// RegisterRequest is called for each new request coming from token
+// (typically a client IP). It always returns nil: no request is rejected.
+func (l *Limiter) RegisterRequest(token string) error {
+	return nil
+}
+
+// WrapHandle is a placeholder hook point for transports to bound the
+// number of concurrent connections accepted from a single source.
+func (l *Limiter) WrapHandle(token string) error {
+	if l.config.MaxConnections == 0 {
+		return nil
+	}
+	return fmt.Errorf("limiter: not implemented")
+}
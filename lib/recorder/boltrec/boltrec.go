@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package boltrec implements recorder.Recorder, storing each session's
+// chunks in memory under its own key.
+package boltrec
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/gravitational/teleport/lib/recorder"
+)
+
+type boltRecorder struct {
+	mu   sync.Mutex
+	dir  string
+	data map[string]*bytes.Buffer
+}
+
+// New creates an in-memory recorder; dir is recorded but nothing is
+// written to it.
+func New(dir string) (recorder.Recorder, error) {
+	return &boltRecorder{dir: dir, data: make(map[string]*bytes.Buffer)}, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func (r *boltRecorder) GetChunkWriter(id string) (io.WriteCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf, ok := r.data[id]
+	if !ok {
+		buf = &bytes.Buffer{}
+		r.data[id] = buf
+	}
+	return nopCloser{buf}, nil
+}
+
+func (r *boltRecorder) GetChunkReader(id string) (io.ReadCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf, ok := r.data[id]
+	if !ok {
+		buf = &bytes.Buffer{}
+	}
+	return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+}
+
+func (r *boltRecorder) Close() error {
+	return nil
+}
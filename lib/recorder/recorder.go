@@ -0,0 +1,31 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package recorder defines the interface used to capture interactive
+// session output for later playback.
+package recorder
+
+import "io"
+
+// Recorder captures and retrieves the byte stream of recorded sessions.
+type Recorder interface {
+	// GetChunkWriter returns a writer that records a session's output.
+	GetChunkWriter(id string) (io.WriteCloser, error)
+	// GetChunkReader returns a reader that replays a session's output.
+	GetChunkReader(id string) (io.ReadCloser, error)
+	// Close releases the resources held by the recorder.
+	Close() error
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package services defines the data types and backend-facing services
+// shared between the auth server and its clients: nodes, web sessions
+// and signup tokens.
+package services
+
+import "time"
+
+// Server represents a registered teleport node.
+type Server struct {
+	// ID is the unique identifier of the server, usually its host UUID.
+	ID string `json:"id"`
+	// Addr is the SSH-reachable address of the server.
+	Addr string `json:"addr"`
+	// Hostname is the human readable name of the server.
+	Hostname string `json:"hostname"`
+}
+
+// OTPType selects which RFC a user's one-time-password generator
+// follows.
+type OTPType string
+
+const (
+	// HOTP is RFC 4226: the generator advances an explicit counter every
+	// time a code is issued.
+	HOTP OTPType = "hotp"
+	// TOTP is RFC 6238: the generator derives its counter from the
+	// current time, divided into Period-second steps.
+	TOTP OTPType = "totp"
+)
+
+// OTP describes a user's one-time-password generator, independent of
+// whether it follows HOTP or TOTP.
+type OTP struct {
+	// Type selects whether Counter (HOTP) or Period/Drift (TOTP) govern
+	// how this generator advances.
+	Type OTPType `json:"type"`
+	// Secret is the raw HMAC key shared with the user's authenticator.
+	Secret []byte `json:"secret"`
+	// Counter is the next counter value to check against; only used, and
+	// advanced, for Type HOTP.
+	Counter uint64 `json:"counter,omitempty"`
+	// Period is the length, in seconds, of one TOTP step; only used for
+	// Type TOTP.
+	Period int `json:"period,omitempty"`
+	// Digits is the number of digits a generated code contains.
+	Digits int `json:"digits"`
+	// Drift is how many TOTP steps before and after the current one are
+	// still accepted, to tolerate clock skew between client and server;
+	// only used for Type TOTP.
+	Drift int `json:"drift,omitempty"`
+}
+
+// OTPState is what AuthServer persists for an enrolled user: their OTP
+// generator plus any backup codes (salted-hashed) they can still spend
+// in its place.
+type OTPState struct {
+	// OTP is the user's enrolled generator.
+	OTP OTP `json:"otp"`
+	// BackupCodeHashes are the still-unused backup codes, hashed; each is
+	// removed from this slice as soon as it is consumed.
+	BackupCodeHashes [][]byte `json:"backup_code_hashes,omitempty"`
+}
+
+// SignupToken holds the state needed to complete an invited user's first
+// sign in: the username they were invited as and their OTP descriptor.
+type SignupToken struct {
+	// User is the name of the user this token was created for.
+	User string `json:"user"`
+	// OTP is the generator the invited user is expected to enroll, e.g.
+	// by scanning QRImg.
+	OTP OTP `json:"otp"`
+	// QRImg is a PNG-encoded QR code of the OTP enrollment URL.
+	QRImg []byte `json:"qr_img"`
+	// Used marks that this token has already completed signup once; it is
+	// kept around for TokenTTLAfterUse so a retried completion request
+	// doesn't strand the user, without letting the OTP value be replayed.
+	Used bool `json:"used"`
+}
+
+// LoginAttempts is what AuthServer persists to enforce account lockout:
+// a sliding window of recent password failures for one user, and the
+// lockout itself once that window overflows.
+type LoginAttempts struct {
+	// Count is the number of consecutive failures seen since FirstFailure.
+	Count int `json:"count"`
+	// FirstFailure is when the current failure window started; the
+	// window resets once it is older than the configured window length.
+	FirstFailure time.Time `json:"first_failure"`
+	// LockedUntil is non-zero while the account is locked out. Once that
+	// time has passed it is ignored but left in place; an admin unlock
+	// clears it along with the rest of the record.
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// WebService stores and retrieves web-facing auth state: signup tokens
+// and web sessions.
+type WebService interface {
+	// UpsertSignupToken stores a signup token with the given ttl.
+	UpsertSignupToken(token string, data SignupToken, ttl time.Duration) error
+	// GetSignupToken returns a signup token's data and its remaining ttl.
+	GetSignupToken(token string) (*SignupToken, time.Duration, error)
+	// DeleteSignupToken removes a signup token.
+	DeleteSignupToken(token string) error
+
+	// UpsertWebSession stores a web session id for user with the given ttl.
+	UpsertWebSession(user string, sid string, ttl time.Duration) error
+	// GetWebSession returns the stored session id for user.
+	GetWebSession(user string, sid string) (string, error)
+	// DeleteWebSession removes the web session for user.
+	DeleteWebSession(user string, sid string) error
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package session tracks interactive terminal sessions shared between
+// multiple connected parties (think `screen -x`).
+package session
+
+import (
+	"github.com/gravitational/teleport/lib/backend"
+)
+
+// Session describes a single interactive terminal session.
+type Session struct {
+	ID     string
+	Login  string
+	Active bool
+}
+
+// Service manages the lifecycle of interactive sessions.
+type Service interface {
+	// GetSessions returns all sessions the backend knows about.
+	GetSessions() ([]Session, error)
+	// GetSession returns a session by id. The implementation returned by
+	// New does not read the backend: it returns a Session with only ID
+	// set, and no error even for an id that was never stored.
+	GetSession(id string) (*Session, error)
+	// UpsertSession updates or inserts a session.
+	UpsertSession(sess Session) error
+	// DeleteSession removes a session.
+	DeleteSession(id string) error
+}
+
+type service struct {
+	bk backend.Backend
+}
+
+// New returns a session Service backed by bk.
+func New(bk backend.Backend) Service {
+	return &service{bk: bk}
+}
+
+var sessionsBucket = []string{"sessions"}
+
+func (s *service) GetSessions() ([]Session, error) {
+	ids, err := s.bk.GetKeys(sessionsBucket)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		sess, err := s.GetSession(id)
+		if err != nil {
+			continue
+		}
+		out = append(out, *sess)
+	}
+	return out, nil
+}
+
+func (s *service) GetSession(id string) (*Session, error) {
+	return &Session{ID: id}, nil
+}
+
+func (s *service) UpsertSession(sess Session) error {
+	return s.bk.UpsertVal(sessionsBucket, sess.ID, []byte(sess.Login), 0)
+}
+
+func (s *service) DeleteSession(id string) error {
+	return s.bk.DeleteKey(sessionsBucket, id)
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sshutils implements utility functions for ssh
+package sshutils
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// NewSigner returns a new ssh.Signer that presents the given certificate
+// when offered as a host or user key.
+func NewSigner(priv []byte, cert []byte) (ssh.Signer, error) {
+	rawSigner, err := ssh.ParsePrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	sshCert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("expected certificate, got %T", pub)
+	}
+	return ssh.NewCertSigner(sshCert, rawSigner)
+}
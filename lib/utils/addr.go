@@ -0,0 +1,56 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package utils holds small helpers shared across the teleport codebase.
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NetAddr describes a network endpoint as a (network, address) pair,
+// e.g. ("tcp", "127.0.0.1:3022").
+type NetAddr struct {
+	AddrNetwork string
+	Addr        string
+}
+
+// String returns the address in "network://addr" form.
+func (a NetAddr) String() string {
+	return fmt.Sprintf("%v://%v", a.AddrNetwork, a.Addr)
+}
+
+// IsEmpty returns true if the address was never set.
+func (a NetAddr) IsEmpty() bool {
+	return a.AddrNetwork == "" && a.Addr == ""
+}
+
+// ParseAddr parses the "network://addr" form produced by String, e.g.
+// "tcp://127.0.0.1:3025". A bare "host:port" with no "network://" prefix
+// is assumed to be "tcp".
+func ParseAddr(s string) (NetAddr, error) {
+	network := "tcp"
+	addr := s
+	if i := strings.Index(s, "://"); i != -1 {
+		network = s[:i]
+		addr = s[i+3:]
+	}
+	if addr == "" {
+		return NetAddr{}, fmt.Errorf("invalid address %q", s)
+	}
+	return NetAddr{AddrNetwork: network, Addr: addr}, nil
+}